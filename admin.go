@@ -0,0 +1,166 @@
+package statslogdrain
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// appNameRegexp matches app names that work as basic auth user names and in /drains/<app>
+var appNameRegexp = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+type adminApp struct {
+	Name        string   `json:"name"`
+	Password    string   `json:"password,omitempty"`
+	Credentials []string `json:"credentials,omitempty"`
+}
+
+type adminHandler struct {
+	registry *Registry
+	admin    Authenticator
+}
+
+// NewAdminHandler returns the admin API managing the apps of registry,
+// for requests authenticated by admin:
+//
+//	GET    /admin/apps               lists the apps and the names of their credentials
+//	POST   /admin/apps               adds the app {"name": .., "password": ..}
+//	DELETE /admin/apps/{app}         removes the app
+//	POST   /admin/apps/{app}/rotate  replaces the app's password {"password": ..}
+//
+// Passwords left out are generated and returned in the response,
+// all passwords are stored as bcrypt hashes.
+func NewAdminHandler(registry *Registry, admin Authenticator) http.Handler {
+	return &adminHandler{registry: registry, admin: admin}
+}
+
+func (h *adminHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	userName, valid := authenticateRequestWith(w, req, h.admin, "")
+	if !valid {
+		return
+	}
+
+	log.Println("admin request by", userName+":", req.Method, req.URL.Path)
+
+	if req.URL.Path != "/admin/apps" && !strings.HasPrefix(req.URL.Path, "/admin/apps/") {
+		http.NotFound(w, req)
+		return
+	}
+
+	path := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.Path, "/admin/apps"), "/"), "/")
+	switch {
+	case req.Method == "GET" && path[0] == "":
+		h.listApps(w, req)
+	case req.Method == "POST" && path[0] == "":
+		h.addApp(w, req)
+	case req.Method == "DELETE" && len(path) == 1 && path[0] != "":
+		h.removeApp(w, path[0])
+	case req.Method == "POST" && len(path) == 2 && path[1] == "rotate":
+		h.rotateApp(w, req, path[0])
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (h *adminHandler) listApps(w http.ResponseWriter, req *http.Request) {
+	apps := []adminApp{}
+	for _, name := range h.registry.Apps() {
+		credentials, err := h.registry.CredentialNames(name)
+		if err != nil {
+			continue
+		}
+		apps = append(apps, adminApp{Name: name, Credentials: credentials})
+	}
+	writeJSON(w, 200, apps)
+}
+
+func (h *adminHandler) addApp(w http.ResponseWriter, req *http.Request) {
+	var app adminApp
+	if err := json.NewDecoder(req.Body).Decode(&app); err != nil || app.Name == "" {
+		http.Error(w, "expected JSON body with a name", 400)
+		return
+	}
+	if !appNameRegexp.MatchString(app.Name) {
+		http.Error(w, "app names may only contain lowercase letters, digits and dashes", 400)
+		return
+	}
+
+	credential, generated, err := newCredential(app.Password)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := h.registry.Add(app.Name, credential); err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	writeJSON(w, 201, adminApp{Name: app.Name, Password: generated})
+}
+
+func (h *adminHandler) removeApp(w http.ResponseWriter, name string) {
+	if err := h.registry.Remove(name); err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (h *adminHandler) rotateApp(w http.ResponseWriter, req *http.Request, name string) {
+	var app adminApp
+	if req.ContentLength != 0 {
+		if err := json.NewDecoder(req.Body).Decode(&app); err != nil {
+			http.Error(w, "expected JSON body", 400)
+			return
+		}
+	}
+
+	credential, generated, err := newCredential(app.Password)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := h.registry.Rotate(name, credential); err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	writeJSON(w, 200, adminApp{Name: name, Password: generated})
+}
+
+// newCredential hashes password, generating one if empty.
+// The generated password is returned so it can be passed on once.
+func newCredential(password string) (Credential, string, error) {
+	generated := ""
+	if password == "" {
+		var err error
+		if password, err = GeneratePassword(); err != nil {
+			return Credential{}, "", err
+		}
+		generated = password
+	}
+
+	hash, err := HashPassword(Bcrypt, password)
+	if err != nil {
+		return Credential{}, "", err
+	}
+	return Credential{Hash: hash}, generated, nil
+}
+
+func writeRegistryError(w http.ResponseWriter, err error) {
+	switch err {
+	case ErrAppNotFound:
+		http.Error(w, err.Error(), 404)
+	case ErrAppExists, ErrAppStatic:
+		http.Error(w, err.Error(), 409)
+	default:
+		log.Println("error updating registry:", err)
+		http.Error(w, "cannot update registry", 500)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
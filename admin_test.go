@@ -0,0 +1,90 @@
+package statslogdrain
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func adminRequest(handler http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, "http://example.com"+path, strings.NewReader(body))
+	req.SetBasicAuth("admin", "s3cret")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestAdminAPI(t *testing.T) {
+	initServer()
+	registry, _ := NewRegistry("", map[string][]Credential{"static-app": {{Name: "current", Password: "f00"}}})
+	handler := NewAdminHandler(registry, BasicAuthenticator{"admin": "s3cret"})
+
+	w := adminRequest(handler, "POST", "/admin/apps", `{"name": "test-app", "password": "deadbeef"}`)
+	assert.Equal(t, 201, w.Code)
+	assert.Equal(t, "{\"name\":\"test-app\"}\n", w.Body.String())
+	assert.True(t, authenticateWith(registry, "test-app", "deadbeef"))
+
+	w = adminRequest(handler, "POST", "/admin/apps", `{"name": "test-app"}`)
+	assert.Equal(t, 409, w.Code)
+
+	for _, name := range []string{"a/b", "user:name", "Test-App"} {
+		w = adminRequest(handler, "POST", "/admin/apps", `{"name": "`+name+`"}`)
+		assert.Equal(t, 400, w.Code, name)
+	}
+
+	w = adminRequest(handler, "POST", "/admin/apps/test-app/rotate", "")
+	assert.Equal(t, 200, w.Code)
+	var rotated adminApp
+	json.Unmarshal(w.Body.Bytes(), &rotated)
+	assert.Len(t, rotated.Password, 48)
+	assert.True(t, authenticateWith(registry, "test-app", rotated.Password))
+	assert.True(t, authenticateWith(registry, "test-app", "deadbeef"))
+
+	w = adminRequest(handler, "GET", "/admin/apps", "")
+	assert.Equal(t, 200, w.Code)
+	var apps []adminApp
+	json.Unmarshal(w.Body.Bytes(), &apps)
+	assert.Equal(t, []adminApp{
+		{Name: "static-app", Credentials: []string{"current"}},
+		{Name: "test-app", Credentials: []string{"current", "previous"}},
+	}, apps)
+
+	w = adminRequest(handler, "DELETE", "/admin/apps/static-app", "")
+	assert.Equal(t, 409, w.Code)
+	w = adminRequest(handler, "DELETE", "/admin/apps/test-app", "")
+	assert.Equal(t, 204, w.Code)
+	w = adminRequest(handler, "DELETE", "/admin/apps/test-app", "")
+	assert.Equal(t, 404, w.Code)
+}
+
+func TestAdminAPIUnauthorized(t *testing.T) {
+	initServer()
+	registry, _ := NewRegistry("", nil)
+	handler := NewAdminHandler(registry, BasicAuthenticator{"admin": "s3cret"})
+
+	req, _ := http.NewRequest("POST", "http://example.com/admin/apps", strings.NewReader(`{"name": "test-app"}`))
+	req.SetBasicAuth("admin", "wrong")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 401, w.Code)
+	assert.Empty(t, registry.Apps())
+}
+
+func TestAdminAPILockout(t *testing.T) {
+	initServer()
+	SetAuthLockout(1, time.Minute, time.Minute)
+	registry, _ := NewRegistry("", nil)
+	handler := NewAdminHandler(registry, BasicAuthenticator{"admin": "s3cret"})
+
+	req, _ := http.NewRequest("GET", "http://example.com/admin/apps", nil)
+	req.SetBasicAuth("admin", "wrong")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	w := adminRequest(handler, "GET", "/admin/apps", "")
+	assert.Equal(t, 429, w.Code)
+}
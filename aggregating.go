@@ -0,0 +1,146 @@
+package statslogdrain
+
+import (
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+)
+
+// AggregatingConfig configures the sink aggregating metrics in-process
+type AggregatingConfig struct {
+	// FlushInterval is how often the aggregates are sent, default 10s
+	FlushInterval time.Duration
+	// HealthName names the sink in /healthz, default aggregating
+	HealthName string
+}
+
+// AggregatingSink aggregates metrics in-process and sends only the
+// aggregates to its sink every flush interval, instead of a packet per
+// value. Counts are summed, gauges keep the last value and sets send each
+// unique value once. Histograms and timings are kept in mergeable sketches
+// per name and tags and sent as the gauges .p50, .p95, .p99 and .max and
+// the count .count. Events and service checks are sent right away.
+// It does not implement TimestampedSink: aggregates are sent at the time
+// of the flush, dropping the times the lines were logged.
+type AggregatingSink struct {
+	mutex     sync.Mutex
+	sink      MetricSink
+	metrics   aggregates
+	flushLoop *flushLoop
+}
+
+// NewAggregatingSink returns a sink aggregating for sink, sending until closed
+func NewAggregatingSink(sink MetricSink, config AggregatingConfig) *AggregatingSink {
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = 10 * time.Second
+	}
+	if config.HealthName == "" {
+		config.HealthName = "aggregating"
+	}
+
+	s := &AggregatingSink{sink: sink, metrics: make(aggregates)}
+	s.flushLoop = startFlushLoop(config.HealthName, config.FlushInterval, s.Flush)
+	return s
+}
+
+func (s *AggregatingSink) Gauge(name string, value float64, tags []string, rate float64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.metrics.get(name, kindGauge, tags).value = value
+	return nil
+}
+
+func (s *AggregatingSink) Count(name string, value int64, tags []string, rate float64) error {
+	if rate <= 0 || rate > 1 {
+		rate = 1
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.metrics.get(name, kindCount, tags).value += float64(value) / rate
+	return nil
+}
+
+func (s *AggregatingSink) Histogram(name string, value float64, tags []string, rate float64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	a := s.metrics.get(name, kindHistogram, tags)
+	if a.sketch == nil {
+		a.sketch = newSketch()
+	}
+	a.sketch.add(value)
+	return nil
+}
+
+func (s *AggregatingSink) Set(name string, value string, tags []string, rate float64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	a := s.metrics.get(name, kindSet, tags)
+	if a.set == nil {
+		a.set = make(map[string]bool)
+	}
+	a.set[value] = true
+	return nil
+}
+
+func (s *AggregatingSink) TimeInMilliseconds(name string, value float64, tags []string, rate float64) error {
+	return s.Histogram(name, value, tags, rate)
+}
+
+func (s *AggregatingSink) Event(e *statsd.Event) error {
+	return s.sink.Event(e)
+}
+
+func (s *AggregatingSink) ServiceCheck(sc *statsd.ServiceCheck) error {
+	return s.sink.ServiceCheck(sc)
+}
+
+// Flush sends the aggregates of the metrics since the last flush,
+// returning the first error while still sending the rest
+func (s *AggregatingSink) Flush() error {
+	s.mutex.Lock()
+	metrics := s.metrics
+	s.metrics = make(aggregates)
+	s.mutex.Unlock()
+
+	var firstErr error
+	send := func(err error) {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, a := range metrics.sorted() {
+		switch a.kind {
+		case kindCount:
+			send(s.sink.Count(a.name, int64(math.Round(a.value)), a.tags, 1))
+		case kindGauge:
+			send(s.sink.Gauge(a.name, a.value, a.tags, 1))
+		case kindSet:
+			for value := range a.set {
+				send(s.sink.Set(a.name, value, a.tags, 1))
+			}
+		case kindHistogram:
+			send(s.sink.Gauge(a.name+".p50", a.sketch.quantile(0.5), a.tags, 1))
+			send(s.sink.Gauge(a.name+".p95", a.sketch.quantile(0.95), a.tags, 1))
+			send(s.sink.Gauge(a.name+".p99", a.sketch.quantile(0.99), a.tags, 1))
+			send(s.sink.Gauge(a.name+".max", a.sketch.max, a.tags, 1))
+			send(s.sink.Count(a.name+".count", int64(a.sketch.count), a.tags, 1))
+		}
+	}
+	return firstErr
+}
+
+// Close stops sending periodically, sends the remaining aggregates and
+// closes its sink if it can be closed
+func (s *AggregatingSink) Close() error {
+	s.flushLoop.stop()
+	err := s.Flush()
+	if closer, ok := s.sink.(io.Closer); ok {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,50 @@
+package statslogdrain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggregatingSink(t *testing.T) {
+	stub := &stubClient{}
+	sink := NewAggregatingSink(stub, AggregatingConfig{FlushInterval: time.Hour})
+
+	tags := []string{"dyno:web.1", "app:myapp"}
+	for i := 1; i <= 100; i++ {
+		sink.Histogram("heroku.router.request.service", float64(i*10), tags, 1)
+	}
+	sink.Count("heroku.logs.errors", 2, tags, 1)
+	sink.Count("heroku.logs.errors", 3, tags, 0.5)
+	sink.Gauge("heroku.dyno.load_avg_1m", 1, tags, 1)
+	sink.Gauge("heroku.dyno.load_avg_1m", 2, tags, 1)
+	sink.Set("heroku.users", "alice", tags, 1)
+	sink.Set("heroku.users", "alice", tags, 1)
+	sink.Event(&statsd.Event{Title: "deploy"})
+	assert.Len(t, stub.events, 1)
+	assert.Empty(t, stub.histograms)
+	assert.Empty(t, stub.counts)
+
+	assert.NoError(t, sink.Close())
+	assert.Empty(t, stub.histograms)
+	expected := []command{
+		{"heroku.dyno.load_avg_1m", 2, tags},
+		{"heroku.router.request.service.p50", 500, tags},
+		{"heroku.router.request.service.p95", 950, tags},
+		{"heroku.router.request.service.p99", 990, tags},
+		{"heroku.router.request.service.max", 1000, tags},
+	}
+	if assert.Len(t, stub.gauges, len(expected)) {
+		for i, gauge := range stub.gauges {
+			assert.Equal(t, expected[i].key, gauge.key)
+			assert.InEpsilon(t, expected[i].value, gauge.value, 2*sketchRelativeAccuracy, gauge.key)
+		}
+	}
+	assert.Equal(t, []command{
+		{"heroku.logs.errors", 8, tags},
+		{"heroku.router.request.service.count", 100, tags},
+	}, stub.counts)
+	assert.Equal(t, []string{"heroku.users:alice"}, stub.sets)
+}
@@ -0,0 +1,182 @@
+package statslogdrain
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// Authenticator identifies the app sending a logdrain request
+type Authenticator interface {
+	// Authenticate returns the name of the app sending req
+	// and whether it is allowed to send to the drain
+	Authenticate(req *http.Request) (string, bool)
+}
+
+// userKnower is implemented by the authenticators that can tell whether
+// a user name is one of theirs, so that metrics only carry known names
+type userKnower interface {
+	knows(userName string) bool
+}
+
+// knownUser returns userName if authenticator knows it, or unknown otherwise,
+// bounding the tags of names sent by unauthenticated clients
+func knownUser(authenticator Authenticator, userName string) string {
+	if knower, ok := authenticator.(userKnower); ok && knower.knows(userName) {
+		return userName
+	}
+	return "unknown"
+}
+
+// AuthenticatorFunc adapts a function to the Authenticator interface
+type AuthenticatorFunc func(req *http.Request) (string, bool)
+
+// Authenticate calls f(req)
+func (f AuthenticatorFunc) Authenticate(req *http.Request) (string, bool) {
+	return f(req)
+}
+
+// BasicAuthenticator authenticates apps by basic auth, mapping app names to passwords.
+// Apps not in the map are rejected.
+type BasicAuthenticator map[string]string
+
+// Authenticate compares the basic auth password of req to the app's password in constant time
+func (a BasicAuthenticator) Authenticate(req *http.Request) (string, bool) {
+	userName, password, ok := req.BasicAuth()
+	if !ok {
+		return userName, false
+	}
+
+	expected, known := a[userName]
+	if !known {
+		return userName, false
+	}
+	return userName, passwordsEqual(password, expected)
+}
+
+func (a BasicAuthenticator) knows(userName string) bool {
+	_, known := a[userName]
+	return known
+}
+
+// Credential is one of the passwords an app can authenticate with
+type Credential struct {
+	// Name tags the heroku.logdrain.authenticated metric, e.g. "current" or "next",
+	// showing which credentials are still in use
+	Name     string `json:"name"`
+	Password string `json:"password,omitempty"`
+	// Hash is a bcrypt or argon2 hash of the password, used instead of Password if set
+	Hash string `json:"hash,omitempty"`
+}
+
+func (c Credential) matches(password string) bool {
+	if c.Hash != "" {
+		return passwordMatchesHash(password, c.Hash)
+	}
+	return passwordsEqual(password, c.Password)
+}
+
+// CredentialsAuthenticator authenticates apps by basic auth against any of their credentials,
+// so that passwords can be rotated without rejecting requests. Apps not in the map are rejected.
+type CredentialsAuthenticator map[string][]Credential
+
+// Authenticate compares the basic auth password of req to all of the app's credentials
+// in constant time and counts the credential used
+func (a CredentialsAuthenticator) Authenticate(req *http.Request) (string, bool) {
+	userName, password, ok := req.BasicAuth()
+	if !ok {
+		return userName, false
+	}
+	return userName, checkCredentials(userName, password, a[userName])
+}
+
+func (a CredentialsAuthenticator) knows(userName string) bool {
+	_, known := a[userName]
+	return known
+}
+
+// checkCredentials compares password to all credentials and counts the one used
+func checkCredentials(userName, password string, credentials []Credential) bool {
+	var used *Credential
+	for i, credential := range credentials {
+		if credential.matches(password) && used == nil {
+			used = &credentials[i]
+		}
+	}
+	if used == nil {
+		return false
+	}
+
+	tags := []string{fmt.Sprintf("credential:%s", used.Name), fmt.Sprintf("app:%v", userName)}
+	recordSend(client.Count("heroku.logdrain.authenticated", 1, tags, 1))
+	return true
+}
+
+// DrainTokenHeader is the header Logplex sends the drain's token in
+const DrainTokenHeader = "Logplex-Drain-Token"
+
+type drainTokenAuthenticator struct {
+	next       Authenticator
+	tokens     map[string]string
+	tokenApps  map[string]bool
+	requireAll bool
+}
+
+// NewDrainTokenAuthenticator returns an Authenticator checking the Logplex-Drain-Token
+// header of requests authenticated by next. tokens maps drain tokens (d.01234567-89ab-...)
+// to app names. Requests of an app with tokens must carry one of them, so that credentials
+// leaked from one app can't be used to send logs claiming to come from another app's drain.
+// With required set, apps without tokens are rejected as well.
+func NewDrainTokenAuthenticator(next Authenticator, tokens map[string]string, required bool) Authenticator {
+	tokenApps := make(map[string]bool)
+	for _, app := range tokens {
+		tokenApps[app] = true
+	}
+	return &drainTokenAuthenticator{next: next, tokens: tokens, tokenApps: tokenApps, requireAll: required}
+}
+
+func (a *drainTokenAuthenticator) Authenticate(req *http.Request) (string, bool) {
+	userName, ok := a.next.Authenticate(req)
+	if !ok {
+		return userName, false
+	}
+
+	tokenApp, known := a.tokens[req.Header.Get(DrainTokenHeader)]
+	if known {
+		return userName, tokenApp == userName
+	}
+	return userName, !a.requireAll && !a.tokenApps[userName]
+}
+
+func (a *drainTokenAuthenticator) knows(userName string) bool {
+	return knownUser(a.next, userName) == userName
+}
+
+// passwordsEqual compares hashes of the passwords so that neither
+// their contents nor their lengths can be timed
+func passwordsEqual(password, expected string) bool {
+	passwordSum := sha256.Sum256([]byte(password))
+	expectedSum := sha256.Sum256([]byte(expected))
+	return subtle.ConstantTimeCompare(passwordSum[:], expectedSum[:]) == 1
+}
+
+var (
+	authenticator      Authenticator = BasicAuthenticator(nil)
+	authenticatorMutex sync.RWMutex
+)
+
+func currentAuthenticator() Authenticator {
+	authenticatorMutex.RLock()
+	defer authenticatorMutex.RUnlock()
+	return authenticator
+}
+
+// SetAuthenticator sets how the apps sending to the drain are authenticated.
+// It is safe to call while the drain is serving requests.
+func SetAuthenticator(a Authenticator) {
+	authenticatorMutex.Lock()
+	defer authenticatorMutex.Unlock()
+	authenticator = a
+}
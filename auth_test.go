@@ -0,0 +1,114 @@
+package statslogdrain
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBasicAuthenticator(t *testing.T) {
+	auth := BasicAuthenticator{"test-app": "deadbeef", "empty-app": ""}
+
+	req, _ := http.NewRequest("POST", "http://example.com/foo", nil)
+	req.SetBasicAuth("test-app", "deadbeef")
+	userName, ok := auth.Authenticate(req)
+	assert.Equal(t, "test-app", userName)
+	assert.True(t, ok)
+
+	req.SetBasicAuth("test-app", "deadbee")
+	_, ok = auth.Authenticate(req)
+	assert.False(t, ok)
+
+	req.SetBasicAuth("unknown-app", "")
+	userName, ok = auth.Authenticate(req)
+	assert.Equal(t, "unknown-app", userName)
+	assert.False(t, ok)
+
+	req.SetBasicAuth("empty-app", "")
+	_, ok = auth.Authenticate(req)
+	assert.True(t, ok)
+
+	req, _ = http.NewRequest("POST", "http://example.com/foo", nil)
+	_, ok = auth.Authenticate(req)
+	assert.False(t, ok)
+}
+
+func TestCustomAuthenticator(t *testing.T) {
+	initServer()
+	SetAuthenticator(AuthenticatorFunc(func(req *http.Request) (string, bool) {
+		return "token-app", req.Header.Get("Authorization") == "Bearer s3cret"
+	}))
+
+	req, _ := http.NewRequest("POST", "http://example.com/foo", strings.NewReader(strings.TrimSpace(customMetricsBody)))
+	req.Header.Set("Authorization", "Bearer s3cret")
+	w := httptest.NewRecorder()
+	LogdrainServer(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, []command{
+		{"heroku.custom.s3_request.total", 537, []string{"source:logdrain-metrics", "app:token-app"}},
+	}, client.(*stubClient).histograms)
+
+	req, _ = http.NewRequest("POST", "http://example.com/foo", strings.NewReader(""))
+	w = httptest.NewRecorder()
+	LogdrainServer(w, req)
+	assert.Equal(t, 401, w.Code)
+}
+
+func TestCredentialsAuthenticator(t *testing.T) {
+	initServer()
+	SetUserCredentials(map[string][]Credential{
+		"test-app": {{Name: "current", Password: "deadbeef"}, {Name: "next", Password: "cafebabe"}},
+	})
+
+	for _, password := range []string{"deadbeef", "cafebabe", "deadbeef"} {
+		req, _ := http.NewRequest("POST", "http://example.com/foo", strings.NewReader(""))
+		req.SetBasicAuth("test-app", password)
+		w := httptest.NewRecorder()
+		LogdrainServer(w, req)
+		assert.Equal(t, 200, w.Code)
+	}
+
+	req, _ := http.NewRequest("POST", "http://example.com/foo", strings.NewReader(""))
+	req.SetBasicAuth("test-app", "wrong")
+	w := httptest.NewRecorder()
+	LogdrainServer(w, req)
+	assert.Equal(t, 401, w.Code)
+
+	assert.Equal(t, []command{
+		{"heroku.logdrain.authenticated", 1, []string{"credential:current", "app:test-app"}},
+		{"heroku.logdrain.authenticated", 1, []string{"credential:next", "app:test-app"}},
+		{"heroku.logdrain.authenticated", 1, []string{"credential:current", "app:test-app"}},
+		{"heroku.logdrain.auth.failed", 1, []string{"user:test-app", "ip_range:unknown"}},
+	}, client.(*stubClient).counts)
+}
+
+func TestDrainTokenAuthenticator(t *testing.T) {
+	credentials := BasicAuthenticator{"test-app": "deadbeef", "other-app": "cafebabe", "tokenless-app": "f00"}
+	tokens := map[string]string{"d.test": "test-app", "d.other": "other-app"}
+
+	for _, c := range []struct {
+		user, password, token string
+		required, ok          bool
+	}{
+		{"test-app", "deadbeef", "d.test", false, true},
+		{"test-app", "deadbeef", "d.other", false, false},
+		{"test-app", "deadbeef", "", false, false},
+		{"test-app", "wrong", "d.test", false, false},
+		{"tokenless-app", "f00", "", false, true},
+		{"tokenless-app", "f00", "d.test", false, false},
+		{"tokenless-app", "f00", "", true, false},
+		{"other-app", "cafebabe", "d.other", true, true},
+	} {
+		req, _ := http.NewRequest("POST", "http://example.com/foo", nil)
+		req.SetBasicAuth(c.user, c.password)
+		if c.token != "" {
+			req.Header.Set(DrainTokenHeader, c.token)
+		}
+		userName, ok := NewDrainTokenAuthenticator(credentials, tokens, c.required).Authenticate(req)
+		assert.Equal(t, c.user, userName)
+		assert.Equal(t, c.ok, ok, "%+v", c)
+	}
+}
@@ -0,0 +1,367 @@
+package statslogdrain
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+)
+
+// DatadogAPIConfig configures the sink posting to the Datadog HTTP API
+type DatadogAPIConfig struct {
+	// APIKey is the Datadog API key, required
+	APIKey string
+	// Site is the Datadog site, e.g. datadoghq.eu, or the URL of the API, default datadoghq.com
+	Site string
+	// FlushInterval is how often the aggregated metrics are posted, default 10s
+	FlushInterval time.Duration
+	// BatchSize is the maximum number of series per request, default 500
+	BatchSize int
+	// MaxRetries is how often a failed request is retried, default 3
+	MaxRetries int
+	// HTTPClient sends the requests, default a client with a 10s timeout
+	HTTPClient *http.Client
+	// HealthName names the sink in /healthz, default datadog-api
+	HealthName string
+}
+
+// DatadogAPISink aggregates metrics in-process and posts them to the
+// Datadog API every flush interval, without a local Datadog agent.
+// Counts are summed, gauges keep the last value, sets count their unique
+// values and histograms and timings are sent like the agent does, as
+// .avg, .count, .median, .95percentile and .max.
+type DatadogAPISink struct {
+	mutex         sync.Mutex
+	config        DatadogAPIConfig
+	url           string
+	series        aggregates
+	events        []*statsd.Event
+	serviceChecks []*statsd.ServiceCheck
+	retryBackoff  time.Duration
+	flushLoop     *flushLoop
+}
+
+type aggregateKind int
+
+const (
+	kindCount aggregateKind = iota
+	kindGauge
+	kindHistogram
+	kindSet
+)
+
+type aggregate struct {
+	name   string
+	kind   aggregateKind
+	tags   []string
+	value  float64
+	values []float64
+	set    map[string]bool
+	sketch *sketch
+}
+
+// aggregates collects the metrics of a flush interval by name, kind and tags
+type aggregates map[string]*aggregate
+
+func (as aggregates) get(name string, kind aggregateKind, tags []string) *aggregate {
+	key := fmt.Sprintf("%s|%d|%s", name, kind, strings.Join(tags, ","))
+	a, ok := as[key]
+	if !ok {
+		a = &aggregate{name: name, kind: kind, tags: append([]string(nil), tags...)}
+		as[key] = a
+	}
+	return a
+}
+
+// sorted returns the aggregates ordered by name, kind and tags
+func (as aggregates) sorted() []*aggregate {
+	keys := make([]string, 0, len(as))
+	for key := range as {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	sorted := make([]*aggregate, 0, len(keys))
+	for _, key := range keys {
+		sorted = append(sorted, as[key])
+	}
+	return sorted
+}
+
+// NewDatadogAPISink returns a sink for config, flushing until closed
+func NewDatadogAPISink(config DatadogAPIConfig) (*DatadogAPISink, error) {
+	if config.APIKey == "" {
+		return nil, fmt.Errorf("Datadog API sink needs an API key")
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = 10 * time.Second
+	}
+	if config.HealthName == "" {
+		config.HealthName = "datadog-api"
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 500
+	}
+	if config.MaxRetries <= 0 {
+		config.MaxRetries = 3
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	sink := &DatadogAPISink{
+		config:       config,
+		url:          datadogAPIURL(config.Site),
+		series:       make(aggregates),
+		retryBackoff: time.Second,
+	}
+	sink.flushLoop = startFlushLoop(config.HealthName, config.FlushInterval, sink.Flush)
+	return sink, nil
+}
+
+func datadogAPIURL(site string) string {
+	switch {
+	case site == "":
+		return "https://api.datadoghq.com"
+	case strings.HasPrefix(site, "http://") || strings.HasPrefix(site, "https://"):
+		return strings.TrimSuffix(site, "/")
+	default:
+		return "https://api." + site
+	}
+}
+
+// Close stops flushing periodically and posts what was aggregated since the last flush
+func (s *DatadogAPISink) Close() error {
+	s.flushLoop.stop()
+	return s.Flush()
+}
+
+func (s *DatadogAPISink) Gauge(name string, value float64, tags []string, rate float64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.series.get(name, kindGauge, tags).value = value
+	return nil
+}
+
+func (s *DatadogAPISink) Count(name string, value int64, tags []string, rate float64) error {
+	if rate <= 0 || rate > 1 {
+		rate = 1
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.series.get(name, kindCount, tags).value += float64(value) / rate
+	return nil
+}
+
+func (s *DatadogAPISink) Histogram(name string, value float64, tags []string, rate float64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	a := s.series.get(name, kindHistogram, tags)
+	a.values = append(a.values, value)
+	return nil
+}
+
+func (s *DatadogAPISink) Set(name string, value string, tags []string, rate float64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	a := s.series.get(name, kindSet, tags)
+	if a.set == nil {
+		a.set = make(map[string]bool)
+	}
+	a.set[value] = true
+	return nil
+}
+
+func (s *DatadogAPISink) TimeInMilliseconds(name string, value float64, tags []string, rate float64) error {
+	return s.Histogram(name, value, tags, rate)
+}
+
+func (s *DatadogAPISink) Event(e *statsd.Event) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.events = append(s.events, e)
+	return nil
+}
+
+func (s *DatadogAPISink) ServiceCheck(sc *statsd.ServiceCheck) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.serviceChecks = append(s.serviceChecks, sc)
+	return nil
+}
+
+type datadogSeries struct {
+	Metric   string       `json:"metric"`
+	Points   [][2]float64 `json:"points"`
+	Type     string       `json:"type"`
+	Interval int64        `json:"interval,omitempty"`
+	Tags     []string     `json:"tags,omitempty"`
+}
+
+type datadogEvent struct {
+	Title          string   `json:"title"`
+	Text           string   `json:"text"`
+	DateHappened   int64    `json:"date_happened,omitempty"`
+	Host           string   `json:"host,omitempty"`
+	AggregationKey string   `json:"aggregation_key,omitempty"`
+	Priority       string   `json:"priority,omitempty"`
+	SourceTypeName string   `json:"source_type_name,omitempty"`
+	AlertType      string   `json:"alert_type,omitempty"`
+	Tags           []string `json:"tags,omitempty"`
+}
+
+type datadogServiceCheck struct {
+	Check     string   `json:"check"`
+	Status    int      `json:"status"`
+	Timestamp int64    `json:"timestamp,omitempty"`
+	HostName  string   `json:"host_name,omitempty"`
+	Message   string   `json:"message,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+}
+
+// Flush posts the metrics, events and service checks aggregated since the last flush
+func (s *DatadogAPISink) Flush() error {
+	s.mutex.Lock()
+	metrics, events, serviceChecks := s.series, s.events, s.serviceChecks
+	s.series, s.events, s.serviceChecks = make(aggregates), nil, nil
+	s.mutex.Unlock()
+
+	now := time.Now()
+	series := s.toSeries(metrics, now)
+
+	var errs []string
+	for start := 0; start < len(series); start += s.config.BatchSize {
+		end := start + s.config.BatchSize
+		if end > len(series) {
+			end = len(series)
+		}
+		if err := s.post("/api/v1/series", map[string][]datadogSeries{"series": series[start:end]}); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	for _, e := range events {
+		if err := s.post("/api/v1/events", toDatadogEvent(e, now)); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(serviceChecks) > 0 {
+		checks := make([]datadogServiceCheck, 0, len(serviceChecks))
+		for _, sc := range serviceChecks {
+			checks = append(checks, toDatadogServiceCheck(sc, now))
+		}
+		if err := s.post("/api/v1/check_run", checks); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (s *DatadogAPISink) toSeries(metrics aggregates, now time.Time) []datadogSeries {
+	timestamp := float64(now.Unix())
+	interval := int64(s.config.FlushInterval.Seconds())
+	point := func(value float64) [][2]float64 { return [][2]float64{{timestamp, value}} }
+
+	series := []datadogSeries{}
+	for _, a := range metrics.sorted() {
+		switch a.kind {
+		case kindCount:
+			series = append(series, datadogSeries{Metric: a.name, Points: point(a.value), Type: "count", Interval: interval, Tags: a.tags})
+		case kindGauge:
+			series = append(series, datadogSeries{Metric: a.name, Points: point(a.value), Type: "gauge", Tags: a.tags})
+		case kindSet:
+			series = append(series, datadogSeries{Metric: a.name, Points: point(float64(len(a.set))), Type: "gauge", Tags: a.tags})
+		case kindHistogram:
+			sum, count := a.sortValues()
+			series = append(series,
+				datadogSeries{Metric: a.name + ".avg", Points: point(sum / count), Type: "gauge", Tags: a.tags},
+				datadogSeries{Metric: a.name + ".count", Points: point(count), Type: "count", Interval: interval, Tags: a.tags},
+				datadogSeries{Metric: a.name + ".median", Points: point(percentile(a.values, 0.5)), Type: "gauge", Tags: a.tags},
+				datadogSeries{Metric: a.name + ".95percentile", Points: point(percentile(a.values, 0.95)), Type: "gauge", Tags: a.tags},
+				datadogSeries{Metric: a.name + ".max", Points: point(a.values[len(a.values)-1]), Type: "gauge", Tags: a.tags},
+			)
+		}
+	}
+	return series
+}
+
+// sortValues sorts the values of a histogram and returns their sum and count
+func (a *aggregate) sortValues() (sum, count float64) {
+	sort.Float64s(a.values)
+	for _, v := range a.values {
+		sum += v
+	}
+	return sum, float64(len(a.values))
+}
+
+// percentile returns the nearest-rank percentile p of the sorted values
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+func toDatadogEvent(e *statsd.Event, now time.Time) datadogEvent {
+	timestamp := e.Timestamp
+	if timestamp.IsZero() {
+		timestamp = now
+	}
+	return datadogEvent{
+		Title:          e.Title,
+		Text:           e.Text,
+		DateHappened:   timestamp.Unix(),
+		Host:           e.Hostname,
+		AggregationKey: e.AggregationKey,
+		Priority:       string(e.Priority),
+		SourceTypeName: e.SourceTypeName,
+		AlertType:      string(e.AlertType),
+		Tags:           e.Tags,
+	}
+}
+
+func toDatadogServiceCheck(sc *statsd.ServiceCheck, now time.Time) datadogServiceCheck {
+	timestamp := sc.Timestamp
+	if timestamp.IsZero() {
+		timestamp = now
+	}
+	return datadogServiceCheck{
+		Check:     sc.Name,
+		Status:    int(sc.Status),
+		Timestamp: timestamp.Unix(),
+		HostName:  sc.Hostname,
+		Message:   sc.Message,
+		Tags:      sc.Tags,
+	}
+}
+
+// post sends body gzipped as JSON to path
+func (s *DatadogAPISink) post(path string, body interface{}) error {
+	var buffer bytes.Buffer
+	gz := gzip.NewWriter(&buffer)
+	if err := json.NewEncoder(gz).Encode(body); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	headers := map[string]string{
+		"Content-Type":     "application/json",
+		"Content-Encoding": "gzip",
+		"DD-API-KEY":       s.config.APIKey,
+	}
+	return postWithRetries(s.config.HTTPClient, s.url+path, headers, buffer.Bytes(), s.config.MaxRetries, s.retryBackoff)
+}
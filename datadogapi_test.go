@@ -0,0 +1,127 @@
+package statslogdrain
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/stretchr/testify/assert"
+)
+
+type datadogStub struct {
+	sync.Mutex
+	server   *httptest.Server
+	failures int
+	requests map[string][]json.RawMessage
+}
+
+func newDatadogStub(t *testing.T) *datadogStub {
+	stub := &datadogStub{requests: make(map[string][]json.RawMessage)}
+	stub.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		stub.Lock()
+		defer stub.Unlock()
+		if stub.failures > 0 {
+			stub.failures--
+			http.Error(w, "unavailable", 503)
+			return
+		}
+
+		assert.Equal(t, "secret-key", req.Header.Get("DD-API-KEY"))
+		assert.Equal(t, "gzip", req.Header.Get("Content-Encoding"))
+		body, err := gzip.NewReader(req.Body)
+		assert.NoError(t, err)
+		var payload json.RawMessage
+		assert.NoError(t, json.NewDecoder(body).Decode(&payload))
+		stub.requests[req.URL.Path] = append(stub.requests[req.URL.Path], payload)
+		w.WriteHeader(202)
+	}))
+	return stub
+}
+
+func newTestDatadogAPISink(t *testing.T, stub *datadogStub, batchSize int) *DatadogAPISink {
+	sink, err := NewDatadogAPISink(DatadogAPIConfig{APIKey: "secret-key", Site: stub.server.URL, FlushInterval: time.Hour, BatchSize: batchSize})
+	assert.NoError(t, err)
+	sink.retryBackoff = time.Millisecond
+	return sink
+}
+
+func TestDatadogAPISinkAggregates(t *testing.T) {
+	stub := newDatadogStub(t)
+	defer stub.server.Close()
+	sink := newTestDatadogAPISink(t, stub, 0)
+
+	tags := []string{"app:test-app"}
+	sink.Count("heroku.dyno.exit", 1, tags, 1)
+	sink.Count("heroku.dyno.exit", 2, tags, 1)
+	sink.Gauge("heroku.custom.queue.depth", 3, tags, 1)
+	sink.Gauge("heroku.custom.queue.depth", 5, tags, 1)
+	sink.Set("heroku.custom.users", "alice", tags, 1)
+	sink.Set("heroku.custom.users", "alice", tags, 1)
+	sink.Set("heroku.custom.users", "bob", tags, 1)
+	for _, v := range []float64{10, 20, 30, 40} {
+		sink.Histogram("heroku.router.request.service", v, tags, 1)
+	}
+	sink.Event(&statsd.Event{Title: "Config vars set on test-app", Text: "FOO set by me@example.com", Tags: tags})
+	sink.ServiceCheck(&statsd.ServiceCheck{Name: "heroku.app.up", Status: statsd.Critical, Tags: tags})
+	assert.NoError(t, sink.Close())
+
+	var series struct{ Series []datadogSeries }
+	assert.Len(t, stub.requests["/api/v1/series"], 1)
+	assert.NoError(t, json.Unmarshal(stub.requests["/api/v1/series"][0], &series))
+	values := map[string]float64{}
+	types := map[string]string{}
+	for _, s := range series.Series {
+		assert.Equal(t, tags, s.Tags)
+		values[s.Metric] = s.Points[0][1]
+		types[s.Metric] = s.Type
+	}
+	assert.Equal(t, map[string]float64{
+		"heroku.dyno.exit":                           3,
+		"heroku.custom.queue.depth":                  5,
+		"heroku.custom.users":                        2,
+		"heroku.router.request.service.avg":          25,
+		"heroku.router.request.service.count":        4,
+		"heroku.router.request.service.median":       20,
+		"heroku.router.request.service.95percentile": 40,
+		"heroku.router.request.service.max":          40,
+	}, values)
+	assert.Equal(t, "count", types["heroku.dyno.exit"])
+	assert.Equal(t, "gauge", types["heroku.custom.queue.depth"])
+
+	var event datadogEvent
+	assert.NoError(t, json.Unmarshal(stub.requests["/api/v1/events"][0], &event))
+	assert.Equal(t, "Config vars set on test-app", event.Title)
+
+	var checks []datadogServiceCheck
+	assert.NoError(t, json.Unmarshal(stub.requests["/api/v1/check_run"][0], &checks))
+	assert.Equal(t, []datadogServiceCheck{{Check: "heroku.app.up", Status: 2, Timestamp: checks[0].Timestamp, Tags: tags}}, checks)
+}
+
+func TestDatadogAPISinkBatchesAndRetries(t *testing.T) {
+	stub := newDatadogStub(t)
+	defer stub.server.Close()
+	stub.failures = 2
+	sink := newTestDatadogAPISink(t, stub, 2)
+
+	for _, name := range []string{"a", "b", "c"} {
+		sink.Count("heroku.logs."+name, 1, nil, 1)
+	}
+	assert.NoError(t, sink.Close())
+	assert.Len(t, stub.requests["/api/v1/series"], 2)
+
+	stub.failures = 10
+	sink = newTestDatadogAPISink(t, stub, 0)
+	sink.Count("heroku.logs.a", 1, nil, 1)
+	assert.EqualError(t, sink.Close(), "POST /api/v1/series: 503 Service Unavailable")
+}
+
+func TestDatadogAPIURL(t *testing.T) {
+	assert.Equal(t, "https://api.datadoghq.com", datadogAPIURL(""))
+	assert.Equal(t, "https://api.datadoghq.eu", datadogAPIURL("datadoghq.eu"))
+	assert.Equal(t, "http://127.0.0.1:8080", datadogAPIURL("http://127.0.0.1:8080/"))
+}
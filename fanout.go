@@ -0,0 +1,183 @@
+package statslogdrain
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+)
+
+// SinkFilter selects the metrics a sink of a FanoutSink receives by metric
+// name and app. Names are matched against patterns like heroku.router.*,
+// apps by name. Empty include lists include everything, excludes win.
+// Events are matched by the name events, service checks by their name.
+type SinkFilter struct {
+	IncludeMetrics []string
+	ExcludeMetrics []string
+	IncludeApps    []string
+	ExcludeApps    []string
+}
+
+func (f SinkFilter) allows(name string, tags []string) bool {
+	if len(f.IncludeMetrics) > 0 && !matchesAny(f.IncludeMetrics, name) {
+		return false
+	}
+	if matchesAny(f.ExcludeMetrics, name) {
+		return false
+	}
+	if len(f.IncludeApps) == 0 && len(f.ExcludeApps) == 0 {
+		return true
+	}
+
+	app := appFromTags(tags)
+	if len(f.IncludeApps) > 0 && !containsString(f.IncludeApps, app) {
+		return false
+	}
+	return !containsString(f.ExcludeApps, app)
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// appFromTags returns the value of the app tag, which the drain appends last
+func appFromTags(tags []string) string {
+	for i := len(tags) - 1; i >= 0; i-- {
+		if strings.HasPrefix(tags[i], "app:") {
+			return strings.TrimPrefix(tags[i], "app:")
+		}
+	}
+	return ""
+}
+
+// fanoutTarget is a sink of a FanoutSink with its filter and error counter
+type fanoutTarget struct {
+	name   string
+	sink   MetricSink
+	filter SinkFilter
+	errors *int64
+}
+
+// FanoutSink sends every metric to each of its sinks whose filter allows it,
+// e.g. to write to two backends while migrating. A sink failing or panicking
+// does not keep the metric from the other sinks, its errors are counted per sink.
+type FanoutSink struct {
+	targets []fanoutTarget
+}
+
+// NewFanoutSink returns a sink without any sinks to send to yet
+func NewFanoutSink() *FanoutSink {
+	return &FanoutSink{}
+}
+
+// Add adds the sink named name receiving the metrics filter allows.
+// Sinks must be added before the FanoutSink is used.
+func (f *FanoutSink) Add(name string, sink MetricSink, filter SinkFilter) {
+	f.targets = append(f.targets, fanoutTarget{name: name, sink: sink, filter: filter, errors: new(int64)})
+}
+
+// Errors returns how many sends failed per sink name
+func (f *FanoutSink) Errors() map[string]int64 {
+	errors := make(map[string]int64)
+	for _, target := range f.targets {
+		errors[target.name] = atomic.LoadInt64(target.errors)
+	}
+	return errors
+}
+
+// Close closes the sinks that can be closed, flushing what they buffered
+func (f *FanoutSink) Close() error {
+	var errs []string
+	for _, target := range f.targets {
+		if closer, ok := target.sink.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", target.name, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// WithTimestamp implements TimestampedSink for the sinks that implement it
+func (f *FanoutSink) WithTimestamp(timestamp time.Time) MetricSink {
+	targets := make([]fanoutTarget, len(f.targets))
+	for i, target := range f.targets {
+		if timestamped, ok := target.sink.(TimestampedSink); ok {
+			target.sink = timestamped.WithTimestamp(timestamp)
+		}
+		targets[i] = target
+	}
+	return &FanoutSink{targets: targets}
+}
+
+// send calls send for every sink allowing the metric name with tags,
+// counting and recording failures and returning them together
+func (f *FanoutSink) send(name string, tags []string, send func(sink MetricSink) error) error {
+	var errs sinkErrors
+	for _, target := range f.targets {
+		if !target.filter.allows(name, tags) {
+			continue
+		}
+		err := target.send(send)
+		health.record(sendName(target.name), err)
+		if err != nil {
+			atomic.AddInt64(target.errors, 1)
+			errs = append(errs, sinkError{sink: target.name, err: err})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (t fanoutTarget) send(send func(sink MetricSink) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("sink %s panicked: %v", t.name, r)
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return send(t.sink)
+}
+
+func (f *FanoutSink) Gauge(name string, value float64, tags []string, rate float64) error {
+	return f.send(name, tags, func(sink MetricSink) error { return sink.Gauge(name, value, tags, rate) })
+}
+
+func (f *FanoutSink) Count(name string, value int64, tags []string, rate float64) error {
+	return f.send(name, tags, func(sink MetricSink) error { return sink.Count(name, value, tags, rate) })
+}
+
+func (f *FanoutSink) Histogram(name string, value float64, tags []string, rate float64) error {
+	return f.send(name, tags, func(sink MetricSink) error { return sink.Histogram(name, value, tags, rate) })
+}
+
+func (f *FanoutSink) Set(name string, value string, tags []string, rate float64) error {
+	return f.send(name, tags, func(sink MetricSink) error { return sink.Set(name, value, tags, rate) })
+}
+
+func (f *FanoutSink) TimeInMilliseconds(name string, value float64, tags []string, rate float64) error {
+	return f.send(name, tags, func(sink MetricSink) error { return sink.TimeInMilliseconds(name, value, tags, rate) })
+}
+
+func (f *FanoutSink) Event(e *statsd.Event) error {
+	return f.send("events", e.Tags, func(sink MetricSink) error { return sink.Event(e) })
+}
+
+func (f *FanoutSink) ServiceCheck(sc *statsd.ServiceCheck) error {
+	return f.send(sc.Name, sc.Tags, func(sink MetricSink) error { return sink.ServiceCheck(sc) })
+}
@@ -0,0 +1,83 @@
+package statslogdrain
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingClient struct {
+	stubClient
+	panics bool
+}
+
+func (c *failingClient) Histogram(name string, value float64, tags []string, rate float64) error {
+	if c.panics {
+		panic("boom")
+	}
+	return errors.New("connection refused")
+}
+
+func TestFanoutSinkFilters(t *testing.T) {
+	datadog, prometheus := &stubClient{}, &stubClient{}
+	fanout := NewFanoutSink()
+	fanout.Add("datadog", datadog, SinkFilter{ExcludeApps: []string{"staging-app"}})
+	fanout.Add("prometheus", prometheus, SinkFilter{IncludeMetrics: []string{"heroku.router.*"}, ExcludeMetrics: []string{"heroku.router.request.bytes"}})
+
+	fanout.Histogram("heroku.router.request.service", 37, []string{"dyno:web.1", "app:test-app"}, 1)
+	fanout.Histogram("heroku.router.request.bytes", 828, []string{"dyno:web.1", "app:test-app"}, 1)
+	fanout.Histogram("heroku.router.request.service", 12, []string{"dyno:web.1", "app:staging-app"}, 1)
+	fanout.Count("heroku.dyno.exit", 1, []string{"app:test-app"}, 1)
+	fanout.Event(&statsd.Event{Title: "Config vars set on test-app", Tags: []string{"app:test-app"}})
+
+	assert.Equal(t, []command{
+		{"heroku.router.request.service", 37, []string{"dyno:web.1", "app:test-app"}},
+		{"heroku.router.request.bytes", 828, []string{"dyno:web.1", "app:test-app"}},
+	}, datadog.histograms)
+	assert.Equal(t, []command{{"heroku.dyno.exit", 1, []string{"app:test-app"}}}, datadog.counts)
+	assert.Len(t, datadog.events, 1)
+
+	assert.Equal(t, []command{
+		{"heroku.router.request.service", 37, []string{"dyno:web.1", "app:test-app"}},
+		{"heroku.router.request.service", 12, []string{"dyno:web.1", "app:staging-app"}},
+	}, prometheus.histograms)
+	assert.Empty(t, prometheus.counts)
+	assert.Empty(t, prometheus.events)
+}
+
+func TestFanoutSinkIsolatesFailures(t *testing.T) {
+	useSinkHealth(t, 2)
+	healthy := &stubClient{}
+	fanout := NewFanoutSink()
+	fanout.Add("broken", &failingClient{}, SinkFilter{})
+	fanout.Add("panicking", &failingClient{panics: true}, SinkFilter{})
+	fanout.Add("healthy", healthy, SinkFilter{})
+
+	err := fanout.Histogram("heroku.router.request.service", 37, nil, 1)
+	assert.EqualError(t, err, "broken: connection refused; panicking: panic: boom")
+	assert.NoError(t, fanout.Count("heroku.dyno.exit", 1, nil, 1))
+	fanout.Histogram("heroku.router.request.service", 37, nil, 1)
+
+	assert.Len(t, healthy.histograms, 2)
+	assert.Equal(t, map[string]int64{"broken": 2, "panicking": 2, "healthy": 0}, fanout.Errors())
+
+	_, status := healthz(t)
+	assert.Equal(t, 1, status.Sinks["broken/send"].ConsecutiveFailures)
+	assert.Equal(t, map[string]int64{"panic": 2}, status.Sinks["panicking/send"].Errors)
+	_, recorded := status.Sinks["healthy/send"]
+	assert.False(t, recorded)
+}
+
+func TestFanoutSinkTimestamps(t *testing.T) {
+	influx := &InfluxSink{}
+	fanout := NewFanoutSink()
+	fanout.Add("influx", influx, SinkFilter{})
+	fanout.Add("statsd", &stubClient{}, SinkFilter{})
+
+	timestamped := fanout.WithTimestamp(time.Unix(1, 0))
+	assert.NoError(t, timestamped.Count("heroku.dyno.exit", 1, nil, 1))
+	assert.Equal(t, []string{"heroku.dyno.exit value=1i 1000000000"}, influx.lines)
+}
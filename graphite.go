@@ -0,0 +1,400 @@
+package statslogdrain
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+)
+
+// NameTemplate folds tags into metric names for backends without tags,
+// like plain statsd and Graphite. A template is a dot-separated path of
+// literals and placeholders:
+//
+//	{name}    the metric name, e.g. heroku.router.request.service
+//	{name:N}  the metric name without its first N segments, e.g. router.request.service for {name:1}
+//	{tag}     the value of the tag, e.g. {app} or {process_type}, which falls back to the dyno's type
+//
+// Segments whose placeholders have no value are left out, so
+// heroku.{app}.{process_type}.{name:1} turns heroku.router.request.service
+// tagged app:myapp and dyno:web.1 into heroku.myapp.web.router.request.service.
+type NameTemplate struct {
+	segments []string
+}
+
+// DefaultNameTemplate keeps the metric names and drops their tags
+var DefaultNameTemplate = NameTemplate{segments: []string{"{name}"}}
+
+var (
+	placeholderRegexp     = regexp.MustCompile(`\{([^{}]*)\}`)
+	invalidPathCharRegexp = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+)
+
+// ParseNameTemplate parses template, see NameTemplate
+func ParseNameTemplate(template string) (NameTemplate, error) {
+	if template == "" {
+		return DefaultNameTemplate, nil
+	}
+	segments := strings.Split(template, ".")
+	for _, segment := range segments {
+		if segment == "" {
+			return NameTemplate{}, fmt.Errorf("empty segment in name template %q", template)
+		}
+		if strings.Count(segment, "{") != strings.Count(segment, "}") {
+			return NameTemplate{}, fmt.Errorf("unbalanced braces in name template %q", template)
+		}
+		for _, match := range placeholderRegexp.FindAllStringSubmatch(segment, -1) {
+			if match[1] == "" {
+				return NameTemplate{}, fmt.Errorf("empty placeholder in name template %q", template)
+			}
+			if strings.HasPrefix(match[1], "name:") {
+				if _, err := strconv.Atoi(strings.TrimPrefix(match[1], "name:")); err != nil {
+					return NameTemplate{}, fmt.Errorf("cannot parse %s in name template %q", match[0], template)
+				}
+			}
+		}
+	}
+	return NameTemplate{segments: segments}, nil
+}
+
+// Name returns the path of the metric name with tags
+func (t NameTemplate) Name(name string, tags []string) string {
+	values := tagValues(tags)
+	path := make([]string, 0, len(t.segments))
+	for _, segment := range t.segments {
+		empty := false
+		resolved := placeholderRegexp.ReplaceAllStringFunc(segment, func(placeholder string) string {
+			value := resolvePlaceholder(placeholder[1:len(placeholder)-1], name, values)
+			if value == "" {
+				empty = true
+			}
+			return value
+		})
+		if !empty {
+			path = append(path, resolved)
+		}
+	}
+	return strings.Join(path, ".")
+}
+
+func resolvePlaceholder(placeholder, name string, values map[string]string) string {
+	if placeholder == "name" {
+		return name
+	}
+	if strings.HasPrefix(placeholder, "name:") {
+		skip, _ := strconv.Atoi(strings.TrimPrefix(placeholder, "name:"))
+		segments := strings.Split(name, ".")
+		if skip >= len(segments) {
+			return ""
+		}
+		return strings.Join(segments[skip:], ".")
+	}
+	return invalidPathCharRegexp.ReplaceAllString(values[placeholder], "_")
+}
+
+// tagValues maps the keys of key:value tags to their values, deriving
+// process_type from the dyno unless tagged
+func tagValues(tags []string) map[string]string {
+	values := make(map[string]string)
+	for _, tag := range tags {
+		keyValue := strings.SplitN(tag, ":", 2)
+		if len(keyValue) == 2 {
+			values[keyValue[0]] = keyValue[1]
+		}
+	}
+	if _, ok := values["process_type"]; !ok && values["dyno"] != "" {
+		values["process_type"] = strings.SplitN(values["dyno"], ".", 2)[0]
+	}
+	return values
+}
+
+// PlainStatsdConfig configures the sink sending to a plain, Etsy style statsd
+type PlainStatsdConfig struct {
+	// Address is the host:port of statsd, default 127.0.0.1:8125
+	Address string
+	// Template names the metrics, default DefaultNameTemplate
+	Template NameTemplate
+	// FlushInterval is how often buffered metrics are sent, default 100ms
+	FlushInterval time.Duration
+	// MaxPacketSize limits the bytes per packet, default 1432
+	MaxPacketSize int
+	// HealthName names the sink in /healthz, default statsd-plain
+	HealthName string
+}
+
+// PlainStatsdSink sends metrics in the plain statsd protocol without
+// DogStatsD tags, folding the tags into the names with its template.
+// Histograms are sent as timers, events and service checks are dropped.
+type PlainStatsdSink struct {
+	mutex     sync.Mutex
+	config    PlainStatsdConfig
+	conn      net.Conn
+	lines     []string
+	flushLoop *flushLoop
+}
+
+// NewPlainStatsdSink returns a sink for config, sending until closed
+func NewPlainStatsdSink(config PlainStatsdConfig) (*PlainStatsdSink, error) {
+	if config.Address == "" {
+		config.Address = DefaultStatsdAddress
+	}
+	if config.Template.segments == nil {
+		config.Template = DefaultNameTemplate
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = 100 * time.Millisecond
+	}
+	if config.HealthName == "" {
+		config.HealthName = "statsd-plain"
+	}
+	if config.MaxPacketSize <= 0 {
+		config.MaxPacketSize = statsd.OptimalUDPPayloadSize
+	}
+
+	conn, err := net.Dial("udp", config.Address)
+	if err != nil {
+		return nil, err
+	}
+	sink := &PlainStatsdSink{config: config, conn: conn}
+	sink.flushLoop = startFlushLoop(config.HealthName, config.FlushInterval, sink.Flush)
+	return sink, nil
+}
+
+func (s *PlainStatsdSink) send(name, value, kind string, tags []string, rate float64) error {
+	line := s.config.Template.Name(name, tags) + ":" + value + "|" + kind
+	if rate > 0 && rate < 1 {
+		line += "|@" + strconv.FormatFloat(rate, 'f', -1, 64)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lines = append(s.lines, line)
+	return nil
+}
+
+func (s *PlainStatsdSink) Gauge(name string, value float64, tags []string, rate float64) error {
+	return s.send(name, formatFloat(value), "g", tags, rate)
+}
+
+func (s *PlainStatsdSink) Count(name string, value int64, tags []string, rate float64) error {
+	return s.send(name, strconv.FormatInt(value, 10), "c", tags, rate)
+}
+
+func (s *PlainStatsdSink) Histogram(name string, value float64, tags []string, rate float64) error {
+	return s.send(name, formatFloat(value), "ms", tags, rate)
+}
+
+func (s *PlainStatsdSink) Set(name string, value string, tags []string, rate float64) error {
+	return s.send(name, value, "s", tags, rate)
+}
+
+func (s *PlainStatsdSink) TimeInMilliseconds(name string, value float64, tags []string, rate float64) error {
+	return s.send(name, formatFloat(value), "ms", tags, rate)
+}
+
+func (s *PlainStatsdSink) Event(e *statsd.Event) error {
+	return nil
+}
+
+func (s *PlainStatsdSink) ServiceCheck(sc *statsd.ServiceCheck) error {
+	return nil
+}
+
+// Flush sends the buffered metrics, as many lines per packet as fit
+func (s *PlainStatsdSink) Flush() error {
+	s.mutex.Lock()
+	lines := s.lines
+	s.lines = nil
+	s.mutex.Unlock()
+
+	packet := ""
+	for _, line := range lines {
+		if packet != "" && len(packet)+len(line)+1 > s.config.MaxPacketSize {
+			if _, err := s.conn.Write([]byte(packet)); err != nil {
+				return err
+			}
+			packet = ""
+		}
+		if packet != "" {
+			packet += "\n"
+		}
+		packet += line
+	}
+	if packet == "" {
+		return nil
+	}
+	_, err := s.conn.Write([]byte(packet))
+	return err
+}
+
+// Close stops sending periodically and sends the remaining metrics
+func (s *PlainStatsdSink) Close() error {
+	s.flushLoop.stop()
+	err := s.Flush()
+	if closeErr := s.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// GraphiteConfig configures the sink writing to Graphite's plaintext protocol
+type GraphiteConfig struct {
+	// Address is the host:port of carbon's plaintext listener, usually port 2003
+	Address string
+	// Template names the metrics, default DefaultNameTemplate
+	Template NameTemplate
+	// FlushInterval is how often the aggregated metrics are written, default 10s
+	FlushInterval time.Duration
+	// Timeout limits connecting and writing, default 5s
+	Timeout time.Duration
+	// HealthName names the sink in /healthz, default graphite
+	HealthName string
+}
+
+// GraphiteSink aggregates metrics like statsd does and writes them to
+// Graphite every flush interval, folding the tags into the paths with its
+// template. Counts are summed, gauges keep the last value and sets count
+// their unique values. Histograms and timings are written as .count, .sum,
+// .mean, .lower, .upper and .upper_95. Events and service checks are dropped.
+type GraphiteSink struct {
+	mutex     sync.Mutex
+	config    GraphiteConfig
+	metrics   aggregates
+	flushLoop *flushLoop
+}
+
+// NewGraphiteSink returns a sink for config, writing until closed
+func NewGraphiteSink(config GraphiteConfig) (*GraphiteSink, error) {
+	if config.Address == "" {
+		return nil, fmt.Errorf("Graphite sink needs an address")
+	}
+	if config.Template.segments == nil {
+		config.Template = DefaultNameTemplate
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = 10 * time.Second
+	}
+	if config.HealthName == "" {
+		config.HealthName = "graphite"
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 5 * time.Second
+	}
+
+	sink := &GraphiteSink{config: config, metrics: make(aggregates)}
+	sink.flushLoop = startFlushLoop(config.HealthName, config.FlushInterval, sink.Flush)
+	return sink, nil
+}
+
+func (s *GraphiteSink) get(name string, kind aggregateKind, tags []string) *aggregate {
+	return s.metrics.get(s.config.Template.Name(name, tags), kind, nil)
+}
+
+func (s *GraphiteSink) Gauge(name string, value float64, tags []string, rate float64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.get(name, kindGauge, tags).value = value
+	return nil
+}
+
+func (s *GraphiteSink) Count(name string, value int64, tags []string, rate float64) error {
+	if rate <= 0 || rate > 1 {
+		rate = 1
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.get(name, kindCount, tags).value += float64(value) / rate
+	return nil
+}
+
+func (s *GraphiteSink) Histogram(name string, value float64, tags []string, rate float64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	a := s.get(name, kindHistogram, tags)
+	a.values = append(a.values, value)
+	return nil
+}
+
+func (s *GraphiteSink) Set(name string, value string, tags []string, rate float64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	a := s.get(name, kindSet, tags)
+	if a.set == nil {
+		a.set = make(map[string]bool)
+	}
+	a.set[value] = true
+	return nil
+}
+
+func (s *GraphiteSink) TimeInMilliseconds(name string, value float64, tags []string, rate float64) error {
+	return s.Histogram(name, value, tags, rate)
+}
+
+func (s *GraphiteSink) Event(e *statsd.Event) error {
+	return nil
+}
+
+func (s *GraphiteSink) ServiceCheck(sc *statsd.ServiceCheck) error {
+	return nil
+}
+
+// Flush writes the metrics aggregated since the last flush
+func (s *GraphiteSink) Flush() error {
+	s.mutex.Lock()
+	metrics := s.metrics
+	s.metrics = make(aggregates)
+	s.mutex.Unlock()
+
+	if len(metrics) == 0 {
+		return nil
+	}
+	lines := graphiteLines(metrics, time.Now())
+
+	conn, err := net.DialTimeout("tcp", s.config.Address, s.config.Timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(s.config.Timeout))
+	_, err = conn.Write([]byte(strings.Join(lines, "")))
+	return err
+}
+
+// Close stops writing periodically and writes the remaining metrics
+func (s *GraphiteSink) Close() error {
+	s.flushLoop.stop()
+	return s.Flush()
+}
+
+func graphiteLines(metrics aggregates, now time.Time) []string {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	line := func(path string, value float64) string {
+		return path + " " + formatFloat(value) + " " + timestamp + "\n"
+	}
+
+	lines := []string{}
+	for _, a := range metrics.sorted() {
+		switch a.kind {
+		case kindCount, kindGauge:
+			lines = append(lines, line(a.name, a.value))
+		case kindSet:
+			lines = append(lines, line(a.name, float64(len(a.set))))
+		case kindHistogram:
+			sum, count := a.sortValues()
+			lines = append(lines,
+				line(a.name+".count", count),
+				line(a.name+".sum", sum),
+				line(a.name+".mean", sum/count),
+				line(a.name+".lower", a.values[0]),
+				line(a.name+".upper", a.values[len(a.values)-1]),
+				line(a.name+".upper_95", percentile(a.values, 0.95)),
+			)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,99 @@
+package statslogdrain
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNameTemplate(t *testing.T) {
+	template, err := ParseNameTemplate("heroku.{app}.{process_type}.{name:1}")
+	assert.NoError(t, err)
+
+	tags := []string{"dyno:web.1", "method:GET", "app:myapp"}
+	assert.Equal(t, "heroku.myapp.web.router.request.service", template.Name("heroku.router.request.service", tags))
+	assert.Equal(t, "heroku.myapp.dyno.exit", template.Name("heroku.dyno.exit", []string{"app:myapp"}))
+	assert.Equal(t, "heroku.my_app.worker.dyno.exit", template.Name("heroku.dyno.exit", []string{"app:my.app", "process_type:worker"}))
+
+	template, err = ParseNameTemplate("")
+	assert.NoError(t, err)
+	assert.Equal(t, "heroku.router.request.service", template.Name("heroku.router.request.service", tags))
+
+	template, _ = ParseNameTemplate("{name}.by_dyno.{dyno}")
+	assert.Equal(t, "heroku.dyno.memory_total.by_dyno.web_1", template.Name("heroku.dyno.memory_total", tags))
+
+	for _, invalid := range []string{"heroku..{name}", "heroku.{app", "heroku.{}", "{name:x}"} {
+		_, err := ParseNameTemplate(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestPlainStatsdSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	template, _ := ParseNameTemplate("heroku.{app}.{process_type}.{name:1}")
+	sink, err := NewPlainStatsdSink(PlainStatsdConfig{Address: conn.LocalAddr().String(), Template: template, FlushInterval: time.Hour})
+	assert.NoError(t, err)
+
+	tags := []string{"dyno:web.1", "app:myapp"}
+	sink.Histogram("heroku.router.request.service", 37, tags, 1)
+	sink.Count("heroku.logs.errors", 2, tags, 0.5)
+	sink.Gauge("heroku.dyno.load_avg_1m", 0.25, tags, 1)
+	assert.NoError(t, sink.Close())
+
+	assert.Equal(t, "heroku.myapp.web.router.request.service:37|ms\nheroku.myapp.web.logs.errors:2|c|@0.5\nheroku.myapp.web.dyno.load_avg_1m:0.25|g", readPacket(t, conn))
+}
+
+func TestGraphiteSink(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	received := make(chan []string)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		lines := []string{}
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		received <- lines
+	}()
+
+	template, _ := ParseNameTemplate("heroku.{app}.{name:1}")
+	sink, err := NewGraphiteSink(GraphiteConfig{Address: listener.Addr().String(), Template: template, FlushInterval: time.Hour})
+	assert.NoError(t, err)
+
+	tags := []string{"dyno:web.1", "app:myapp"}
+	for _, v := range []float64{10, 20, 60} {
+		sink.Histogram("heroku.router.request.service", v, tags, 1)
+	}
+	sink.Count("heroku.logs.errors", 2, tags, 1)
+	sink.Count("heroku.logs.errors", 3, []string{"dyno:web.2", "app:myapp"}, 1)
+	assert.NoError(t, sink.Close())
+
+	paths := []string{}
+	for _, line := range <-received {
+		fields := strings.Fields(line)
+		assert.Len(t, fields, 3)
+		paths = append(paths, fields[0]+" "+fields[1])
+	}
+	assert.Equal(t, []string{
+		"heroku.myapp.logs.errors 5",
+		"heroku.myapp.router.request.service.count 3",
+		"heroku.myapp.router.request.service.sum 90",
+		"heroku.myapp.router.request.service.mean 30",
+		"heroku.myapp.router.request.service.lower 10",
+		"heroku.myapp.router.request.service.upper 60",
+		"heroku.myapp.router.request.service.upper_95 60",
+	}, paths)
+}
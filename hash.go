@@ -0,0 +1,193 @@
+package statslogdrain
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashing algorithms supported by HashPassword
+const (
+	Bcrypt   = "bcrypt"
+	Argon2id = "argon2id"
+)
+
+// Argon2id parameters of new hashes, as recommended by RFC 9106 for memory constrained setups
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+	// argon2MaxMemory bounds the memory of hashes that are accepted, 1 GiB in KiB
+	argon2MaxMemory = 1024 * 1024
+)
+
+var errUnknownHash = errors.New("unknown password hash format, expected bcrypt or argon2")
+
+// HashPassword hashes password with algorithm, either Bcrypt or Argon2id.
+// Argon2id hashes use the PHC string format, $argon2id$v=19$m=..,t=..,p=..$<salt>$<hash>.
+func HashPassword(algorithm, password string) (string, error) {
+	switch algorithm {
+	case Bcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		return string(hash), err
+	case Argon2id:
+		salt := make([]byte, argon2SaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argon2Memory, argon2Time, argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	}
+	return "", fmt.Errorf("unknown password hash algorithm %q", algorithm)
+}
+
+// CheckPasswordHash returns an error unless hash is a valid bcrypt or argon2 hash
+func CheckPasswordHash(hash string) error {
+	switch {
+	case isBcryptHash(hash):
+		_, err := bcrypt.Cost([]byte(hash))
+		return err
+	case strings.HasPrefix(hash, "$argon2"):
+		_, err := parseArgon2Hash(hash)
+		return err
+	}
+	return errUnknownHash
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// passwordMatchesHash verifies password against a bcrypt or argon2 hash
+func passwordMatchesHash(password, hash string) bool {
+	if verifiedPasswords.contains(password, hash) {
+		return true
+	}
+
+	var matches bool
+	switch {
+	case isBcryptHash(hash):
+		matches = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "$argon2"):
+		params, err := parseArgon2Hash(hash)
+		matches = err == nil && params.matches(password)
+	}
+
+	if matches {
+		verifiedPasswords.add(password, hash)
+	}
+	return matches
+}
+
+type argon2Hash struct {
+	variant      string
+	memory, time uint32
+	threads      uint8
+	salt, key    []byte
+}
+
+func parseArgon2Hash(hash string) (argon2Hash, error) {
+	var h argon2Hash
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" {
+		return h, fmt.Errorf("invalid argon2 hash, expected 5 $-separated parts")
+	}
+
+	h.variant = parts[1]
+	if h.variant != "argon2id" && h.variant != "argon2i" {
+		return h, fmt.Errorf("unsupported argon2 variant %q", h.variant)
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return h, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads); err != nil {
+		return h, fmt.Errorf("invalid argon2 parameters %q: %v", parts[3], err)
+	}
+	if h.time < 1 || h.threads < 1 || h.memory > argon2MaxMemory {
+		return h, fmt.Errorf("invalid argon2 parameters %q, expected t and p of at least 1 and m of at most %d", parts[3], argon2MaxMemory)
+	}
+
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return h, fmt.Errorf("invalid argon2 salt: %v", err)
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return h, fmt.Errorf("invalid argon2 key: %v", err)
+	}
+	if len(h.key) == 0 {
+		return h, fmt.Errorf("invalid argon2 key: empty")
+	}
+	return h, nil
+}
+
+func (h argon2Hash) matches(password string) bool {
+	var key []byte
+	if h.variant == "argon2id" {
+		key = argon2.IDKey([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+	} else {
+		key = argon2.Key([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+	}
+	return subtle.ConstantTimeCompare(key, h.key) == 1
+}
+
+// maxVerifiedPasswords bounds the cache, which is cleared when full
+const maxVerifiedPasswords = 1024
+
+// verificationCache remembers passwords that matched a hash, so that the
+// hashing cost is paid once per process instead of on every request.
+// Entries are HMACs keyed with a random per-process secret, so the cache
+// never holds the passwords themselves.
+type verificationCache struct {
+	sync.Mutex
+	secret   []byte
+	verified map[string]struct{}
+}
+
+var verifiedPasswords = newVerificationCache()
+
+func newVerificationCache() *verificationCache {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return &verificationCache{secret: secret, verified: make(map[string]struct{})}
+}
+
+func (c *verificationCache) key(password, hash string) string {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(hash))
+	mac.Write([]byte{0})
+	mac.Write([]byte(password))
+	return string(mac.Sum(nil))
+}
+
+func (c *verificationCache) contains(password, hash string) bool {
+	key := c.key(password, hash)
+	c.Lock()
+	defer c.Unlock()
+	_, ok := c.verified[key]
+	return ok
+}
+
+func (c *verificationCache) add(password, hash string) {
+	key := c.key(password, hash)
+	c.Lock()
+	defer c.Unlock()
+	if len(c.verified) >= maxVerifiedPasswords {
+		c.verified = make(map[string]struct{})
+	}
+	c.verified[key] = struct{}{}
+}
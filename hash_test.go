@@ -0,0 +1,64 @@
+package statslogdrain
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashPassword(t *testing.T) {
+	for _, algorithm := range []string{Bcrypt, Argon2id} {
+		hash, err := HashPassword(algorithm, "deadbeef")
+		assert.NoError(t, err)
+		assert.NoError(t, CheckPasswordHash(hash))
+		assert.True(t, passwordMatchesHash("deadbeef", hash), algorithm)
+		assert.False(t, passwordMatchesHash("cafebabe", hash), algorithm)
+	}
+
+	_, err := HashPassword("md5", "deadbeef")
+	assert.Error(t, err)
+}
+
+func TestCheckPasswordHash(t *testing.T) {
+	assert.NoError(t, CheckPasswordHash("$argon2id$v=19$m=65536,t=3,p=4$c2FsdHNhbHRzYWx0c2FsdA$Pv9jtEhgKySkMz3FPxhV0dV5x4hJeSHDM6A0XUuY6PM"))
+	assert.Error(t, CheckPasswordHash("$argon2id$v=19$m=65536,t=3,p=4$c2FsdA"))
+	for _, params := range []string{"m=65536,t=0,p=4", "m=65536,t=3,p=0", "m=4194304,t=3,p=4"} {
+		hash := "$argon2id$v=19$" + params + "$c2FsdHNhbHRzYWx0c2FsdA$Pv9jtEhgKySkMz3FPxhV0dV5x4hJeSHDM6A0XUuY6PM"
+		assert.Error(t, CheckPasswordHash(hash), params)
+		assert.False(t, passwordMatchesHash("deadbeef", hash), params)
+	}
+	assert.Error(t, CheckPasswordHash("$argon2id$v=19$m=65536,t=3,p=4$c2FsdHNhbHRzYWx0c2FsdA$"))
+	assert.Error(t, CheckPasswordHash("$2a$10$tooshort"))
+	assert.Error(t, CheckPasswordHash("deadbeef"))
+}
+
+func TestVerifiedPasswordsAreCached(t *testing.T) {
+	verifiedPasswords = newVerificationCache()
+	hash, _ := HashPassword(Bcrypt, "deadbeef")
+
+	assert.True(t, passwordMatchesHash("deadbeef", hash))
+	assert.True(t, verifiedPasswords.contains("deadbeef", hash))
+	assert.False(t, verifiedPasswords.contains("deadbeef", "$2a$10$other"))
+	assert.False(t, passwordMatchesHash("cafebabe", hash))
+	assert.False(t, verifiedPasswords.contains("cafebabe", hash))
+}
+
+func TestHashedCredentials(t *testing.T) {
+	initServer()
+	hash, _ := bcrypt.GenerateFromPassword([]byte("cafebabe"), bcrypt.MinCost)
+	SetUserCredentials(map[string][]Credential{
+		"test-app": {{Name: "current", Hash: string(hash)}},
+	})
+
+	for password, code := range map[string]int{"cafebabe": 200, "deadbeef": 401, "": 401} {
+		req, _ := http.NewRequest("POST", "http://example.com/foo", strings.NewReader(""))
+		req.SetBasicAuth("test-app", password)
+		w := httptest.NewRecorder()
+		LogdrainServer(w, req)
+		assert.Equal(t, code, w.Code, password)
+	}
+}
@@ -0,0 +1,197 @@
+package statslogdrain
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// DefaultSinkFailureThreshold is how many sends to a sink may fail in a row
+// before /healthz reports the drain as failing
+const DefaultSinkFailureThreshold = 100
+
+// sendErrorLogInterval limits how often the send errors of a sink are logged
+const sendErrorLogInterval = time.Minute
+
+// defaultSinkName names the sink set with SetMetricSink unless it fans out
+const defaultSinkName = "default"
+
+// sendName names the hand-offs of metrics to sink, apart from its writes or
+// flushes, so that metrics accepted into its buffers don't hide failed writes
+func sendName(sink string) string {
+	return sink + "/send"
+}
+
+// sinkHealth tracks the failed sends of each sink
+type sinkHealth struct {
+	mutex     sync.Mutex
+	threshold int
+	sinks     map[string]*sinkFailures
+	// failing counts the sinks whose last send failed, so that
+	// successful sends only need the lock while a sink is failing
+	failing int32
+	now     func() time.Time
+}
+
+// sinkFailures counts the failed sends of a sink by error type
+type sinkFailures struct {
+	errors      map[string]int64
+	consecutive int
+	lastError   string
+	lastLogged  time.Time
+	unlogged    int
+}
+
+func newSinkHealth() *sinkHealth {
+	return &sinkHealth{threshold: DefaultSinkFailureThreshold, sinks: make(map[string]*sinkFailures), now: time.Now}
+}
+
+var health = newSinkHealth()
+
+// SetSinkFailureThreshold sets how many sends to a sink may fail in a row
+// before /healthz reports the drain as failing
+func SetSinkFailureThreshold(threshold int) {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	health.threshold = threshold
+}
+
+// record records the outcome of a send to sink, skipping the failures of
+// sinks a FanoutSink sent to, which it recorded itself
+func (h *sinkHealth) record(sink string, err error) {
+	if err == nil && atomic.LoadInt32(&h.failing) == 0 {
+		return
+	}
+	if _, ok := err.(sinkErrors); ok {
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	failures, ok := h.sinks[sink]
+	if err == nil {
+		if ok && failures.consecutive > 0 {
+			failures.consecutive = 0
+			atomic.AddInt32(&h.failing, -1)
+		}
+		return
+	}
+
+	if !ok {
+		failures = &sinkFailures{errors: make(map[string]int64)}
+		h.sinks[sink] = failures
+	}
+	if failures.consecutive == 0 {
+		atomic.AddInt32(&h.failing, 1)
+	}
+	failures.errors[sendErrorType(err)]++
+	failures.consecutive++
+	failures.lastError = err.Error()
+
+	now := h.now()
+	if now.Sub(failures.lastLogged) < sendErrorLogInterval {
+		failures.unlogged++
+		return
+	}
+	if failures.unlogged > 0 {
+		log.Printf("error sending metrics to %s: %v (%d more errors since last logged)", sink, err, failures.unlogged)
+	} else {
+		log.Printf("error sending metrics to %s: %v", sink, err)
+	}
+	failures.lastLogged, failures.unlogged = now, 0
+}
+
+// sinkErrors are the failures of the sinks of a FanoutSink
+type sinkErrors []sinkError
+
+type sinkError struct {
+	sink string
+	err  error
+}
+
+func (errs sinkErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, e := range errs {
+		messages[i] = e.sink + ": " + e.err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// recordSend records the outcome of handing a metric to the sink set with SetMetricSink
+func recordSend(err error) {
+	if err == errRateLimited {
+		return
+	}
+	health.record(sendName(defaultSinkName), err)
+}
+
+// sendErrorType classifies err for counting, e.g. connection_refused or http_503
+func sendErrorType(err error) string {
+	var statusErr *httpStatusError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &statusErr):
+		return "http_" + statusErr.code()
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection_refused"
+	case errors.Is(err, syscall.ENOENT):
+		return "no_socket"
+	case errors.Is(err, syscall.ENOBUFS) || errors.Is(err, syscall.EAGAIN):
+		return "buffer_full"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, &netErr):
+		return "network"
+	case strings.HasPrefix(err.Error(), "panic: "):
+		return "panic"
+	default:
+		return "other"
+	}
+}
+
+type sinkStatus struct {
+	ConsecutiveFailures int              `json:"consecutive_failures"`
+	LastError           string           `json:"last_error,omitempty"`
+	Errors              map[string]int64 `json:"errors"`
+}
+
+type healthStatus struct {
+	Status string                `json:"status"`
+	Sinks  map[string]sinkStatus `json:"sinks"`
+}
+
+func (h *sinkHealth) status() healthStatus {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	status := healthStatus{Status: "ok", Sinks: make(map[string]sinkStatus)}
+	for name, failures := range h.sinks {
+		errors := make(map[string]int64)
+		for errorType, count := range failures.errors {
+			errors[errorType] = count
+		}
+		status.Sinks[name] = sinkStatus{ConsecutiveFailures: failures.consecutive, LastError: failures.lastError, Errors: errors}
+		if failures.consecutive >= h.threshold {
+			status.Status = "failing"
+		}
+	}
+	return status
+}
+
+// HealthServer answers with the send errors of each sink as JSON, with
+// status 503 once a sink failed as many sends in a row as the threshold
+func HealthServer(w http.ResponseWriter, req *http.Request) {
+	status := health.status()
+	w.Header().Set("Content-Type", "application/json")
+	if status.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,151 @@
+package statslogdrain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func useSinkHealth(t *testing.T, threshold int) *sinkHealth {
+	previous := health
+	health = newSinkHealth()
+	health.threshold = threshold
+	t.Cleanup(func() { health = previous })
+	return health
+}
+
+func healthz(t *testing.T) (int, healthStatus) {
+	w := httptest.NewRecorder()
+	HealthServer(w, httptest.NewRequest("GET", "/healthz", nil))
+	var status healthStatus
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	return w.Code, status
+}
+
+func TestHealthServer(t *testing.T) {
+	useSinkHealth(t, 3)
+	refused := &net.OpError{Op: "write", Net: "udp", Err: syscall.ECONNREFUSED}
+
+	code, status := healthz(t)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "ok", status.Status)
+
+	health.record("statsd", refused)
+	health.record("statsd", refused)
+	health.record("statsd", nil)
+	health.record("statsd", refused)
+	health.record("statsd", refused)
+	code, status = healthz(t)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, 2, status.Sinks["statsd"].ConsecutiveFailures)
+
+	health.record("statsd", refused)
+	health.record("influx", errors.New("disk full"))
+	code, status = healthz(t)
+	assert.Equal(t, 503, code)
+	assert.Equal(t, "failing", status.Status)
+	assert.Equal(t, sinkStatus{ConsecutiveFailures: 3, LastError: "write udp: connection refused", Errors: map[string]int64{"connection_refused": 5}}, status.Sinks["statsd"])
+	assert.Equal(t, map[string]int64{"other": 1}, status.Sinks["influx"].Errors)
+
+	health.record("statsd", nil)
+	code, _ = healthz(t)
+	assert.Equal(t, 200, code)
+}
+
+func TestSinkHealthLogsRateLimited(t *testing.T) {
+	h := useSinkHealth(t, 3)
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	h.now = func() time.Time { return now }
+
+	for i := 0; i < 5; i++ {
+		h.record("statsd", errors.New("broken"))
+	}
+	assert.Equal(t, now, h.sinks["statsd"].lastLogged)
+	assert.Equal(t, 4, h.sinks["statsd"].unlogged)
+
+	now = now.Add(sendErrorLogInterval)
+	h.record("statsd", errors.New("broken"))
+	assert.Equal(t, now, h.sinks["statsd"].lastLogged)
+	assert.Equal(t, 0, h.sinks["statsd"].unlogged)
+}
+
+func TestRecordSend(t *testing.T) {
+	useSinkHealth(t, 1)
+
+	recordSend(errRateLimited)
+	recordSend(sinkErrors{{sink: "influx", err: errors.New("broken")}})
+	_, status := healthz(t)
+	assert.Empty(t, status.Sinks)
+
+	recordSend(errors.New("broken"))
+	_, status = healthz(t)
+	assert.Equal(t, 1, status.Sinks[sendName(defaultSinkName)].ConsecutiveFailures)
+}
+
+func TestSendErrorType(t *testing.T) {
+	assert.Equal(t, "http_503", sendErrorType(fmt.Errorf("posting: %w", &httpStatusError{statusCode: 503})))
+	assert.Equal(t, "connection_refused", sendErrorType(&net.OpError{Op: "write", Err: syscall.ECONNREFUSED}))
+	assert.Equal(t, "no_socket", sendErrorType(&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ENOENT)}))
+	assert.Equal(t, "timeout", sendErrorType(&net.DNSError{IsTimeout: true}))
+	assert.Equal(t, "panic", sendErrorType(errors.New("panic: oops")))
+	assert.Equal(t, "other", sendErrorType(errors.New("oops")))
+}
+
+type failingCountsClient struct {
+	stubClient
+}
+
+func (c *failingCountsClient) Count(name string, value int64, tags []string, rate float64) error {
+	return errors.New("broken pipe")
+}
+
+func TestLogCountersRecordSendErrors(t *testing.T) {
+	initServer()
+	useSinkHealth(t, 1)
+	SetMetricSink(&failingCountsClient{})
+	errors, _ := ParseCounterRule("errors", "level=error")
+	SetAppConfigs(map[string]AppConfig{"test-app": {CounterRules: []CounterRule{errors}}})
+
+	req := httptest.NewRequest("POST", "http://example.com/foo", strings.NewReader(strings.TrimSpace(appLogBody)))
+	req.SetBasicAuth("test-app", "deadbeef")
+	LogdrainServer(httptest.NewRecorder(), req)
+
+	code, status := healthz(t)
+	assert.Equal(t, 503, code)
+	assert.True(t, status.Sinks[sendName(defaultSinkName)].Errors["other"] > 0)
+}
+
+func TestSendsDoNotResetFailedWrites(t *testing.T) {
+	useSinkHealth(t, 5)
+	statsdClient, err := NewStatsdClient(StatsdConfig{Address: "unix://" + filepath.Join(t.TempDir(), "missing.socket"), FlushInterval: 5 * time.Millisecond})
+	assert.NoError(t, err)
+	defer statsdClient.Close()
+	fanout := NewFanoutSink()
+	fanout.Add("statsd", statsdClient, SinkFilter{})
+	aggregating := NewAggregatingSink(&failingCountsClient{}, AggregatingConfig{FlushInterval: 5 * time.Millisecond})
+	defer aggregating.Close()
+
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		assert.NoError(t, fanout.Histogram("heroku.router.request.service", 37, nil, 1))
+		recordSend(aggregating.Histogram("heroku.router.request.service", 37, nil, 1))
+		_, status := healthz(t)
+		if status.Sinks["statsd"].ConsecutiveFailures >= 5 && status.Sinks["aggregating"].ConsecutiveFailures >= 5 {
+			break
+		}
+	}
+	code, status := healthz(t)
+	assert.Equal(t, 503, code)
+	assert.True(t, status.Sinks["statsd"].ConsecutiveFailures >= 5)
+	assert.True(t, status.Sinks["aggregating"].ConsecutiveFailures >= 5)
+	assert.Equal(t, 0, status.Sinks["statsd/send"].ConsecutiveFailures)
+}
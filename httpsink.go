@@ -0,0 +1,100 @@
+package statslogdrain
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// flushLoop calls flush every interval until stopped, for sinks sending
+// batches, recording the outcome of each flush for the sink
+type flushLoop struct {
+	done    chan struct{}
+	stopped sync.WaitGroup
+}
+
+func startFlushLoop(name string, interval time.Duration, flush func() error) *flushLoop {
+	l := &flushLoop{done: make(chan struct{})}
+	l.stopped.Add(1)
+	go func() {
+		defer l.stopped.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				health.record(name, flush())
+			case <-l.done:
+				return
+			}
+		}
+	}()
+	return l
+}
+
+func (l *flushLoop) stop() {
+	close(l.done)
+	l.stopped.Wait()
+}
+
+// postWithRetries posts payload to url, retrying network errors, rate
+// limiting and server errors up to maxRetries times with exponential backoff
+func postWithRetries(client *http.Client, url string, headers map[string]string, payload []byte, maxRetries int, backoff time.Duration) error {
+	var err error
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		var retry bool
+		if retry, err = post(client, url, headers, payload); err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// post posts payload once, reporting whether a failure is worth retrying
+func post(client *http.Client, url string, headers map[string]string, payload []byte) (bool, error) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = &httpStatusError{path: req.URL.Path, status: resp.Status, statusCode: resp.StatusCode}
+	return resp.StatusCode == 429 || resp.StatusCode >= 500, err
+}
+
+// httpStatusError is the error of a request answered with a status other than 2xx
+type httpStatusError struct {
+	path       string
+	status     string
+	statusCode int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("POST %s: %s", e.path, e.status)
+}
+
+func (e *httpStatusError) code() string {
+	return strconv.Itoa(e.statusCode)
+}
@@ -0,0 +1,325 @@
+package statslogdrain
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+)
+
+// InfluxConfig configures the sink writing InfluxDB line protocol
+type InfluxConfig struct {
+	// URL is where the lines are written to:
+	//
+	//	http://host:8086 or https://..  the InfluxDB v2 write API, /api/v2/write
+	//	udp://host:8089                 UDP datagrams, e.g. to Telegraf's socket_listener
+	//	stdout                          standard output, e.g. to pipe into Telegraf
+	//	file:///path/to/metrics.lp      appended to a file
+	URL string
+	// Token, Org and Bucket are used with the write API
+	Token  string
+	Org    string
+	Bucket string
+	// FlushInterval is how often the buffered lines are written, default 10s
+	FlushInterval time.Duration
+	// BatchSize is the maximum number of lines per HTTP request, default 5000
+	BatchSize int
+	// MaxRetries is how often a failed HTTP request is retried, default 3
+	MaxRetries int
+	// HTTPClient sends the requests, default a client with a 10s timeout
+	HTTPClient *http.Client
+	// HealthName names the sink in /healthz, default influx
+	HealthName string
+}
+
+// maxInfluxDatagramSize keeps UDP datagrams within a typical MTU
+const maxInfluxDatagramSize = 1400
+
+// InfluxSink writes every metric as a point in InfluxDB line protocol, the
+// metric name as measurement, the tags as tags and the value as the field
+// value, integer for counts and string for sets. Events are written to the
+// measurement events, service checks with the fields status and message.
+// Metrics are recorded at the time they were logged when it is known.
+type InfluxSink struct {
+	mutex        sync.Mutex
+	config       InfluxConfig
+	lines        []string
+	write        func(lines []string) error
+	close        func() error
+	retryBackoff time.Duration
+	flushLoop    *flushLoop
+}
+
+// NewInfluxSink returns a sink for config, writing until closed
+func NewInfluxSink(config InfluxConfig) (*InfluxSink, error) {
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = 10 * time.Second
+	}
+	if config.HealthName == "" {
+		config.HealthName = "influx"
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 5000
+	}
+	if config.MaxRetries <= 0 {
+		config.MaxRetries = 3
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	sink := &InfluxSink{config: config, retryBackoff: time.Second, close: func() error { return nil }}
+	if err := sink.openWriter(); err != nil {
+		return nil, err
+	}
+	sink.flushLoop = startFlushLoop(config.HealthName, config.FlushInterval, sink.Flush)
+	return sink, nil
+}
+
+func (s *InfluxSink) openWriter() error {
+	if s.config.URL == "stdout" {
+		s.write = writeLinesTo(os.Stdout)
+		return nil
+	}
+
+	u, err := url.Parse(s.config.URL)
+	if err != nil {
+		return fmt.Errorf("cannot parse InfluxDB URL: %v", err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		if s.config.Org == "" || s.config.Bucket == "" {
+			return fmt.Errorf("InfluxDB write API needs an org and a bucket")
+		}
+		s.write = s.writeHTTP
+	case "udp":
+		conn, err := net.Dial("udp", u.Host)
+		if err != nil {
+			return err
+		}
+		s.write, s.close = writeDatagramsTo(conn), conn.Close
+	case "file":
+		file, err := os.OpenFile(u.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		s.write, s.close = writeLinesTo(file), file.Close
+	default:
+		return fmt.Errorf("cannot write InfluxDB lines to %q, expected http(s)://, udp://, file:// or stdout", s.config.URL)
+	}
+	return nil
+}
+
+type stringWriter interface {
+	WriteString(s string) (int, error)
+}
+
+func writeLinesTo(w stringWriter) func(lines []string) error {
+	return func(lines []string) error {
+		_, err := w.WriteString(strings.Join(lines, "\n") + "\n")
+		return err
+	}
+}
+
+// writeDatagramsTo packs as many whole lines into each datagram as fit
+func writeDatagramsTo(conn net.Conn) func(lines []string) error {
+	return func(lines []string) error {
+		datagram := ""
+		for _, line := range lines {
+			if datagram != "" && len(datagram)+len(line)+1 > maxInfluxDatagramSize {
+				if _, err := conn.Write([]byte(datagram)); err != nil {
+					return err
+				}
+				datagram = ""
+			}
+			datagram += line + "\n"
+		}
+		if datagram == "" {
+			return nil
+		}
+		_, err := conn.Write([]byte(datagram))
+		return err
+	}
+}
+
+func (s *InfluxSink) writeHTTP(lines []string) error {
+	query := url.Values{"org": {s.config.Org}, "bucket": {s.config.Bucket}, "precision": {"ns"}}
+	writeURL := strings.TrimSuffix(s.config.URL, "/") + "/api/v2/write?" + query.Encode()
+	headers := map[string]string{"Content-Type": "text/plain; charset=utf-8"}
+	if s.config.Token != "" {
+		headers["Authorization"] = "Token " + s.config.Token
+	}
+
+	for start := 0; start < len(lines); start += s.config.BatchSize {
+		end := start + s.config.BatchSize
+		if end > len(lines) {
+			end = len(lines)
+		}
+		payload := []byte(strings.Join(lines[start:end], "\n") + "\n")
+		if err := postWithRetries(s.config.HTTPClient, writeURL, headers, payload, s.config.MaxRetries, s.retryBackoff); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush writes the lines buffered since the last flush
+func (s *InfluxSink) Flush() error {
+	s.mutex.Lock()
+	lines := s.lines
+	s.lines = nil
+	s.mutex.Unlock()
+
+	if len(lines) == 0 {
+		return nil
+	}
+	return s.write(lines)
+}
+
+// Close stops writing periodically and writes the remaining lines
+func (s *InfluxSink) Close() error {
+	s.flushLoop.stop()
+	err := s.Flush()
+	if closeErr := s.close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// WithTimestamp implements TimestampedSink
+func (s *InfluxSink) WithTimestamp(timestamp time.Time) MetricSink {
+	return &influxPoints{sink: s, timestamp: timestamp}
+}
+
+func (s *InfluxSink) now() *influxPoints {
+	return &influxPoints{sink: s}
+}
+
+func (s *InfluxSink) Gauge(name string, value float64, tags []string, rate float64) error {
+	return s.now().Gauge(name, value, tags, rate)
+}
+
+func (s *InfluxSink) Count(name string, value int64, tags []string, rate float64) error {
+	return s.now().Count(name, value, tags, rate)
+}
+
+func (s *InfluxSink) Histogram(name string, value float64, tags []string, rate float64) error {
+	return s.now().Histogram(name, value, tags, rate)
+}
+
+func (s *InfluxSink) Set(name string, value string, tags []string, rate float64) error {
+	return s.now().Set(name, value, tags, rate)
+}
+
+func (s *InfluxSink) TimeInMilliseconds(name string, value float64, tags []string, rate float64) error {
+	return s.now().TimeInMilliseconds(name, value, tags, rate)
+}
+
+func (s *InfluxSink) Event(e *statsd.Event) error {
+	return s.now().Event(e)
+}
+
+func (s *InfluxSink) ServiceCheck(sc *statsd.ServiceCheck) error {
+	return s.now().ServiceCheck(sc)
+}
+
+// influxPoints records points at timestamp, or when they are recorded if it is zero
+type influxPoints struct {
+	sink      *InfluxSink
+	timestamp time.Time
+}
+
+func (p *influxPoints) record(measurement string, tags []string, fields string, timestamp time.Time) error {
+	if timestamp.IsZero() {
+		timestamp = p.timestamp
+	}
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	line := escapeInfluxName(measurement) + formatInfluxTags(tags) + " " + fields + " " + strconv.FormatInt(timestamp.UnixNano(), 10)
+
+	p.sink.mutex.Lock()
+	defer p.sink.mutex.Unlock()
+	p.sink.lines = append(p.sink.lines, line)
+	return nil
+}
+
+func (p *influxPoints) Gauge(name string, value float64, tags []string, rate float64) error {
+	return p.record(name, tags, "value="+formatFloat(value), time.Time{})
+}
+
+func (p *influxPoints) Count(name string, value int64, tags []string, rate float64) error {
+	return p.record(name, tags, "value="+strconv.FormatInt(value, 10)+"i", time.Time{})
+}
+
+func (p *influxPoints) Histogram(name string, value float64, tags []string, rate float64) error {
+	return p.record(name, tags, "value="+formatFloat(value), time.Time{})
+}
+
+func (p *influxPoints) Set(name string, value string, tags []string, rate float64) error {
+	return p.record(name, tags, "value="+quoteInfluxString(value), time.Time{})
+}
+
+func (p *influxPoints) TimeInMilliseconds(name string, value float64, tags []string, rate float64) error {
+	return p.Histogram(name, value, tags, rate)
+}
+
+func (p *influxPoints) Event(e *statsd.Event) error {
+	fields := "title=" + quoteInfluxString(e.Title) + ",text=" + quoteInfluxString(e.Text)
+	return p.record("events", e.Tags, fields, e.Timestamp)
+}
+
+func (p *influxPoints) ServiceCheck(sc *statsd.ServiceCheck) error {
+	fields := "status=" + strconv.Itoa(int(sc.Status)) + "i"
+	if sc.Message != "" {
+		fields += ",message=" + quoteInfluxString(sc.Message)
+	}
+	return p.record(sc.Name, sc.Tags, fields, sc.Timestamp)
+}
+
+var (
+	influxNameEscaper     = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxTagEscaper      = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	influxStringEscaper   = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	influxNewlineReplacer = strings.NewReplacer("\n", " ", "\r", " ")
+)
+
+func escapeInfluxName(name string) string {
+	return influxNameEscaper.Replace(influxNewlineReplacer.Replace(name))
+}
+
+// formatInfluxTags formats key:value tags as ,key=value sorted by key,
+// skipping tags without a value, which line protocol does not allow
+func formatInfluxTags(tags []string) string {
+	pairs := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		keyValue := strings.SplitN(tag, ":", 2)
+		if len(keyValue) != 2 || keyValue[0] == "" || keyValue[1] == "" {
+			continue
+		}
+		key := influxTagEscaper.Replace(influxNewlineReplacer.Replace(keyValue[0]))
+		value := influxTagEscaper.Replace(influxNewlineReplacer.Replace(keyValue[1]))
+		pairs = append(pairs, key+"="+value)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	sort.Strings(pairs)
+	return "," + strings.Join(pairs, ",")
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func quoteInfluxString(s string) string {
+	return `"` + influxStringEscaper.Replace(s) + `"`
+}
@@ -0,0 +1,96 @@
+package statslogdrain
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInfluxSinkLogTimestamps(t *testing.T) {
+	initServer()
+	path := filepath.Join(t.TempDir(), "metrics.lp")
+	sink, err := NewInfluxSink(InfluxConfig{URL: "file://" + path, FlushInterval: time.Hour})
+	assert.NoError(t, err)
+	SetMetricSink(sink)
+
+	req, _ := http.NewRequest("POST", "http://example.com/foo", strings.NewReader(strings.TrimSpace(routerMetricsBody)))
+	req.SetBasicAuth("test-app", "deadbeef")
+	LogdrainServer(httptest.NewRecorder(), req)
+	assert.NoError(t, sink.Close())
+
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Len(t, lines, 9)
+	assert.Equal(t, "heroku.router.request.bytes,app=test-app,dyno=web.1,host=myapp.com,method=POST,status=201,statusgroup=2xx value=828 1427975554520012000", lines[0])
+	assert.Equal(t, "heroku.router.request.service,app=test-app,code=H12,dyno=web.1,host=myapp.com,method=GET,status=503,statusgroup=5xx value=30001 1427979151520012000", lines[8])
+}
+
+func TestInfluxSinkLineProtocol(t *testing.T) {
+	sink := &InfluxSink{}
+	at := sink.WithTimestamp(time.Unix(1, 5))
+	at.Count("heroku.logs.errors", 3, []string{"app:test-app", "path:/a b,c=d", "empty:"}, 1)
+	at.Set("heroku.custom.users", `say "hi"`, nil, 1)
+	at.Event(&statsd.Event{Title: "Config vars set on test-app", Text: "FOO set", Tags: []string{"app:test-app"}, Timestamp: time.Unix(2, 0)})
+	at.ServiceCheck(&statsd.ServiceCheck{Name: "heroku.app.up", Status: statsd.Warn, Message: "slow"})
+
+	assert.Equal(t, []string{
+		`heroku.logs.errors,app=test-app,path=/a\ b\,c\=d value=3i 1000000005`,
+		`heroku.custom.users value="say \"hi\"" 1000000005`,
+		`events,app=test-app title="Config vars set on test-app",text="FOO set" 2000000000`,
+		`heroku.app.up status=1i,message="slow" 1000000005`,
+	}, sink.lines)
+}
+
+func TestInfluxSinkHTTP(t *testing.T) {
+	var body, query, authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, _ := ioutil.ReadAll(req.Body)
+		body, query, authorization = string(data), req.URL.RawQuery, req.Header.Get("Authorization")
+		assert.Equal(t, "/api/v2/write", req.URL.Path)
+		w.WriteHeader(204)
+	}))
+	defer server.Close()
+
+	sink, err := NewInfluxSink(InfluxConfig{URL: server.URL, Token: "secret", Org: "heroku", Bucket: "drain", FlushInterval: time.Hour})
+	assert.NoError(t, err)
+	sink.WithTimestamp(time.Unix(1, 0)).Gauge("heroku.dyno.load_avg_1m", 0.5, []string{"dyno:web.1"}, 1)
+	assert.NoError(t, sink.Close())
+
+	assert.Equal(t, "heroku.dyno.load_avg_1m,dyno=web.1 value=0.5 1000000000\n", body)
+	assert.Equal(t, "bucket=drain&org=heroku&precision=ns", query)
+	assert.Equal(t, "Token secret", authorization)
+
+	_, err = NewInfluxSink(InfluxConfig{URL: server.URL})
+	assert.EqualError(t, err, "InfluxDB write API needs an org and a bucket")
+}
+
+func TestInfluxSinkUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	sink, err := NewInfluxSink(InfluxConfig{URL: "udp://" + conn.LocalAddr().String(), FlushInterval: time.Hour})
+	assert.NoError(t, err)
+	for i := 0; i < 40; i++ {
+		sink.WithTimestamp(time.Unix(1, 0)).Count("heroku.logs.errors", int64(i), []string{"app:test-app"}, 1)
+	}
+	assert.NoError(t, sink.Close())
+
+	lines := 0
+	for lines < 40 {
+		datagram := readPacket(t, conn)
+		assert.True(t, len(datagram) <= maxInfluxDatagramSize)
+		assert.True(t, strings.HasSuffix(datagram, "\n"))
+		lines += strings.Count(datagram, "\n")
+	}
+	assert.Equal(t, 40, lines)
+}
@@ -0,0 +1,181 @@
+package statslogdrain
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of the failed authentication lockout
+const (
+	DefaultMaxAuthFailures   = 10
+	DefaultAuthFailureWindow = 10 * time.Minute
+	DefaultAuthLockout       = 15 * time.Minute
+)
+
+// maxTrackedFailures bounds the tracked clients, expired entries are swept beyond it
+const maxTrackedFailures = 10000
+
+type authFailure struct {
+	count       int
+	first       time.Time
+	lockedUntil time.Time
+}
+
+// lockoutGuard counts failed authentications per username and source IP and
+// locks out that combination after too many. Locking out usernames alone would
+// let anyone shut an app's drain, locking out IPs alone would lock out all apps
+// sharing Logplex's egress IPs with a misconfigured one.
+type lockoutGuard struct {
+	sync.Mutex
+	maxFailures int
+	window      time.Duration
+	lockout     time.Duration
+	failures    map[string]*authFailure
+}
+
+func newLockoutGuard(maxFailures int, window, lockout time.Duration) *lockoutGuard {
+	return &lockoutGuard{maxFailures: maxFailures, window: window, lockout: lockout, failures: make(map[string]*authFailure)}
+}
+
+var authLockout = newLockoutGuard(DefaultMaxAuthFailures, DefaultAuthFailureWindow, DefaultAuthLockout)
+
+// SetAuthLockout locks out a username and source IP for lockout after maxFailures
+// failed authentications within window. A maxFailures of 0 disables the lockout.
+func SetAuthLockout(maxFailures int, window, lockout time.Duration) {
+	authLockout = newLockoutGuard(maxFailures, window, lockout)
+}
+
+// locked returns how much longer userName is locked out from ip, 0 if not
+func (g *lockoutGuard) locked(userName, ip string, now time.Time) time.Duration {
+	g.Lock()
+	defer g.Unlock()
+
+	failure, ok := g.failures[userName+"@"+ip]
+	if !ok || !now.Before(failure.lockedUntil) {
+		return 0
+	}
+	return failure.lockedUntil.Sub(now)
+}
+
+// fail records a failed authentication and reports whether it caused a lockout
+func (g *lockoutGuard) fail(userName, ip string, now time.Time) bool {
+	if g.maxFailures <= 0 {
+		return false
+	}
+	g.Lock()
+	defer g.Unlock()
+
+	key := userName + "@" + ip
+	failure, ok := g.failures[key]
+	if !ok || now.Sub(failure.first) > g.window {
+		if len(g.failures) >= maxTrackedFailures {
+			g.sweep(now)
+		}
+		failure = &authFailure{first: now}
+		g.failures[key] = failure
+	}
+
+	failure.count++
+	if failure.count < g.maxFailures {
+		return false
+	}
+	failure.lockedUntil = now.Add(g.lockout)
+	failure.count = 0
+	failure.first = failure.lockedUntil
+	return true
+}
+
+func (g *lockoutGuard) succeed(userName, ip string) {
+	g.Lock()
+	defer g.Unlock()
+	delete(g.failures, userName+"@"+ip)
+}
+
+// sweep drops expired entries. The caller must hold the lock.
+func (g *lockoutGuard) sweep(now time.Time) {
+	for key, failure := range g.failures {
+		if now.Sub(failure.first) > g.window && !now.Before(failure.lockedUntil) {
+			delete(g.failures, key)
+		}
+	}
+}
+
+// authenticateRequest authenticates req, as app unless empty, answering it with
+// an error unless valid. Failed attempts are logged with the credentials redacted.
+func authenticateRequest(w http.ResponseWriter, req *http.Request, app string) (string, bool) {
+	return authenticateRequestWith(w, req, currentAuthenticator(), app)
+}
+
+// authenticateRequestWith is authenticateRequest with authenticator, e.g. for the admin API
+func authenticateRequestWith(w http.ResponseWriter, req *http.Request, authenticator Authenticator, app string) (string, bool) {
+	ip := clientIP(req)
+	requestedUser, _, _ := req.BasicAuth()
+	if remaining := authLockout.locked(requestedUser, ip, time.Now()); remaining > 0 {
+		recordSend(client.Count("heroku.logdrain.auth.locked", 1, authTags(authenticator, requestedUser, ip), 1))
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", int(remaining.Seconds())+1))
+		http.Error(w, "Too Many Requests", 429)
+		return requestedUser, false
+	}
+
+	userName, valid := authenticator.Authenticate(req)
+	if valid && app != "" && userName != app {
+		log.Printf("Credentials of user=%q used for app=%q", userName, app)
+		valid = false
+	}
+	if !valid {
+		recordSend(client.Count("heroku.logdrain.auth.failed", 1, authTags(authenticator, userName, ip), 1))
+		log.Printf("Unauthorized request: %s user=%q ip=%s", req.URL.Redacted(), userName, ip)
+		if authLockout.fail(userName, ip, time.Now()) {
+			log.Printf("Locking out user=%q ip=%s for %s", userName, ip, authLockout.lockout)
+		}
+		http.Error(w, "Unauthorized", 401)
+		return userName, false
+	}
+
+	authLockout.succeed(userName, ip)
+	return userName, true
+}
+
+// trustForwardedFor is whether X-Forwarded-For is set by a proxy in front of
+// the drain, by default when running on Heroku behind its router
+var trustForwardedFor = os.Getenv("DYNO") != ""
+
+// SetTrustForwardedFor sets whether the client IP is taken from X-Forwarded-For.
+// Only a proxy in front of the drain, like the Heroku router, can be trusted to
+// set it, clients reaching the drain directly could rotate it to evade the lockout.
+func SetTrustForwardedFor(trust bool) {
+	trustForwardedFor = trust
+}
+
+// clientIP returns the IP of the client sending req. Behind the Heroku router
+// that is the last address of X-Forwarded-For, which the router appends.
+func clientIP(req *http.Request) string {
+	if forwarded := req.Header.Get("X-Forwarded-For"); forwarded != "" && trustForwardedFor {
+		addresses := strings.Split(forwarded, ",")
+		return strings.TrimSpace(addresses[len(addresses)-1])
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// authTags tags failed authentications with the user if authenticator knows
+// it and the /24 or /48 network of the IP, bounding the tags of attacks with
+// many user names or from many IPs
+func authTags(authenticator Authenticator, userName, ip string) []string {
+	network := "unknown"
+	if parsed := net.ParseIP(ip); parsed.To4() != nil {
+		network = parsed.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	} else if parsed != nil {
+		network = parsed.Mask(net.CIDRMask(48, 128)).String() + "/48"
+	}
+	return []string{"user:" + knownUser(authenticator, userName), "ip_range:" + network}
+}
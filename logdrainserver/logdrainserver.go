@@ -1,43 +1,636 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/mat/heroku-datadog-drain-go"
 )
 
 func main() {
-	http.HandleFunc("/", statslogdrain.LogdrainServer)
-	statslogdrain.SetUserpasswords(userPasswordsFromEnv())
+	if len(os.Args) > 1 && os.Args[1] == "hash-password" {
+		hashPassword(os.Args[2:])
+		return
+	}
+
+	if err := configureFromEnv(); err != nil {
+		log.Println("Cannot start:", err)
+		os.Exit(1)
+	}
+	tlsConfig, err := tlsConfigFromEnv()
+	if err == nil {
+		err = setTrustForwardedForFromEnv(tlsConfig != nil)
+	}
+	if err != nil {
+		log.Println("Cannot start:", err)
+		os.Exit(1)
+	}
 	port := os.Getenv("PORT")
 	if port == "" {
 		log.Println("cannot start, need a PORT")
 		os.Exit(1)
 	}
+
+	server := &http.Server{Addr: fmt.Sprintf(":%s", port), TLSConfig: tlsConfig}
+	if tlsConfig != nil {
+		log.Println("Server running with TLS on port", port)
+		log.Fatal(server.ListenAndServeTLS("", ""))
+	}
 	log.Println("Server running on port", port)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", port), nil))
+	log.Fatal(server.ListenAndServe())
+}
+
+// configureFromEnv sets up the drain and its handlers from the environment
+func configureFromEnv() error {
+	sink, exporter, err := metricSinkFromEnv()
+	if err != nil {
+		return err
+	}
+	if sink, err = aggregatedFromEnv(sink, "METRICS_SINK", "aggregating"); err != nil {
+		return err
+	}
+	statslogdrain.SetMetricSink(sink)
+	if exporter != nil {
+		if err := handleMetricsEndpoint(exporter); err != nil {
+			return err
+		}
+	}
+
+	apps, err := allowedAppsFromEnv()
+	if err != nil {
+		return err
+	}
+	registry, err := registryFromEnv(apps)
+	if err != nil {
+		return err
+	}
+
+	http.HandleFunc("/", statslogdrain.LogdrainServer)
+	http.HandleFunc(statslogdrain.DrainsPath, statslogdrain.AppDrainServer)
+	http.HandleFunc("/healthz", statslogdrain.HealthServer)
+	if threshold := os.Getenv("SINK_FAILURE_THRESHOLD"); threshold != "" {
+		failures, err := strconv.Atoi(threshold)
+		if err != nil {
+			return fmt.Errorf("cannot parse SINK_FAILURE_THRESHOLD: %v", err)
+		}
+		statslogdrain.SetSinkFailureThreshold(failures)
+	}
+	adminPassword, err := secretFromEnv("ADMIN_PASSWORD")
+	if err != nil {
+		return err
+	}
+	if adminPassword != "" {
+		admin := statslogdrain.BasicAuthenticator{"admin": adminPassword}
+		http.Handle("/admin/", statslogdrain.NewAdminHandler(registry, admin))
+	}
+
+	statslogdrain.SetAuthenticator(authenticatorFromEnv(apps, registry))
+	if err := setAuthLockoutFromEnv(); err != nil {
+		return err
+	}
+	configs, err := appConfigsFromEnv(apps)
+	if err != nil {
+		return err
+	}
+	statslogdrain.SetAppConfigs(configs)
+	return nil
+}
+
+// metricSinkFromEnv returns the sinks listed in METRICS_SINK, separated by
+// commas, and the exporter to serve on /metrics, if any. It defaults to
+// statsd, also with DD_API_KEY set, which the Datadog agent buildpack
+// needs. Several sinks or a filtered one are fanned out to.
+func metricSinkFromEnv() (statslogdrain.MetricSink, http.Handler, error) {
+	apiKey, err := secretFromEnv("DD_API_KEY")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kinds := os.Getenv("METRICS_SINK")
+	if kinds == "" {
+		kinds = "statsd"
+	}
+
+	fanout := statslogdrain.NewFanoutSink()
+	var sink statslogdrain.MetricSink
+	var exporter http.Handler
+	filtered := false
+	added := make(map[string]bool)
+	for _, kind := range strings.Split(kinds, ",") {
+		kind = strings.TrimSpace(kind)
+		if added[kind] {
+			return nil, nil, fmt.Errorf("METRICS_SINK lists %s twice", kind)
+		}
+		added[kind] = true
+
+		if sink, err = sinkFromEnv(kind, kind, apiKey); err != nil {
+			return nil, nil, err
+		}
+		if handler, ok := sink.(http.Handler); ok {
+			exporter = handler
+		}
+		filter := sinkFilterFromEnv(kind)
+		filtered = filtered || len(filter.IncludeMetrics)+len(filter.ExcludeMetrics)+len(filter.IncludeApps)+len(filter.ExcludeApps) > 0
+		fanout.Add(kind, sink, filter)
+	}
+
+	if len(added) == 1 && !filtered {
+		return sink, exporter, nil
+	}
+	return fanout, exporter, nil
+}
+
+// aggregatedFromEnv wraps sink, of the kinds listed in the variable kindsEnv,
+// to aggregate metrics in-process if AGGREGATE_METRICS is set, which drops
+// the log timestamps the influx sink records. The aggregating sink is named
+// healthName in /healthz.
+func aggregatedFromEnv(sink statslogdrain.MetricSink, kindsEnv, healthName string) (statslogdrain.MetricSink, error) {
+	aggregate, _ := strconv.ParseBool(os.Getenv("AGGREGATE_METRICS"))
+	if !aggregate {
+		return sink, nil
+	}
+	for _, kind := range strings.Split(os.Getenv(kindsEnv), ",") {
+		if strings.TrimSpace(kind) == "influx" {
+			return nil, fmt.Errorf("AGGREGATE_METRICS cannot be combined with %s influx, which records when lines were logged", kindsEnv)
+		}
+	}
+
+	config := statslogdrain.AggregatingConfig{HealthName: healthName}
+	if interval := os.Getenv("AGGREGATION_INTERVAL"); interval != "" {
+		var err error
+		if config.FlushInterval, err = time.ParseDuration(interval); err != nil {
+			return nil, fmt.Errorf("cannot parse AGGREGATION_INTERVAL: %v", err)
+		}
+	}
+	return statslogdrain.NewAggregatingSink(sink, config), nil
+}
+
+// sinkFilterFromEnv reads the filters of a sink from <SINK>_INCLUDE_METRICS,
+// _EXCLUDE_METRICS, _INCLUDE_APPS and _EXCLUDE_APPS, e.g. DATADOG_API_INCLUDE_APPS
+func sinkFilterFromEnv(kind string) statslogdrain.SinkFilter {
+	prefix := strings.ToUpper(strings.Replace(kind, "-", "_", -1))
+	list := func(key string) []string {
+		if value := os.Getenv(prefix + key); value != "" {
+			return strings.Split(value, ",")
+		}
+		return nil
+	}
+	return statslogdrain.SinkFilter{
+		IncludeMetrics: list("_INCLUDE_METRICS"),
+		ExcludeMetrics: list("_EXCLUDE_METRICS"),
+		IncludeApps:    list("_INCLUDE_APPS"),
+		ExcludeApps:    list("_EXCLUDE_APPS"),
+	}
+}
+
+// sinkFromEnv returns the sink of kind: statsd, datadog-api, prometheus,
+// otlp, influx, statsd-plain or graphite, named healthName in /healthz
+func sinkFromEnv(kind, healthName, apiKey string) (statslogdrain.MetricSink, error) {
+	var err error
+	switch kind {
+	case "statsd":
+	case "datadog-api":
+		if apiKey == "" {
+			return nil, errors.New("METRICS_SINK datadog-api needs DD_API_KEY")
+		}
+		config := statslogdrain.DatadogAPIConfig{APIKey: apiKey, Site: os.Getenv("DD_SITE"), HealthName: healthName}
+		if interval := os.Getenv("DD_FLUSH_INTERVAL"); interval != "" {
+			if config.FlushInterval, err = time.ParseDuration(interval); err != nil {
+				return nil, fmt.Errorf("cannot parse DD_FLUSH_INTERVAL: %v", err)
+			}
+		}
+		return statslogdrain.NewDatadogAPISink(config)
+	case "prometheus":
+		config := statslogdrain.PrometheusConfig{}
+		if expiry := os.Getenv("PROMETHEUS_EXPIRY"); expiry != "" {
+			if config.Expiry, err = time.ParseDuration(expiry); err != nil {
+				return nil, fmt.Errorf("cannot parse PROMETHEUS_EXPIRY: %v", err)
+			}
+		}
+		return statslogdrain.NewPrometheusSink(config), nil
+	case "otlp":
+		return otlpSinkFromEnv(healthName)
+	case "influx":
+		return influxSinkFromEnv(healthName)
+	case "statsd-plain", "graphite":
+		return untaggedSinkFromEnv(kind, healthName)
+	default:
+		return nil, fmt.Errorf("cannot parse METRICS_SINK %q, expected statsd, datadog-api, prometheus, otlp, influx, statsd-plain or graphite", kind)
+	}
+
+	statsdConfig, err := statsdConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	statsdConfig.HealthName = healthName
+	statsdClient, err := statslogdrain.NewStatsdClient(statsdConfig)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create statsd client: %v", err)
+	}
+	return statsdClient, nil
+}
+
+// otlpSinkFromEnv configures the OTLP sink with the standard OpenTelemetry
+// variables OTEL_EXPORTER_OTLP_ENDPOINT, OTEL_EXPORTER_OTLP_HEADERS as
+// key=value pairs separated by commas and OTEL_METRIC_EXPORT_INTERVAL in milliseconds
+func otlpSinkFromEnv(healthName string) (statslogdrain.MetricSink, error) {
+	config := statslogdrain.OTLPConfig{Endpoint: os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), Headers: map[string]string{}, HealthName: healthName}
+	if config.Endpoint == "" {
+		return nil, errors.New("METRICS_SINK otlp needs OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+
+	headers, err := secretFromEnv("OTEL_EXPORTER_OTLP_HEADERS")
+	if err != nil {
+		return nil, err
+	}
+	if headers != "" {
+		for _, header := range strings.Split(headers, ",") {
+			keyValue := strings.SplitN(header, "=", 2)
+			if len(keyValue) != 2 {
+				return nil, fmt.Errorf("cannot parse OTEL_EXPORTER_OTLP_HEADERS, expected key=value pairs")
+			}
+			config.Headers[strings.TrimSpace(keyValue[0])] = strings.TrimSpace(keyValue[1])
+		}
+	}
+
+	if interval := os.Getenv("OTEL_METRIC_EXPORT_INTERVAL"); interval != "" {
+		milliseconds, err := strconv.Atoi(interval)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse OTEL_METRIC_EXPORT_INTERVAL: %v", err)
+		}
+		config.FlushInterval = time.Duration(milliseconds) * time.Millisecond
+	}
+	return statslogdrain.NewOTLPSink(config)
+}
+
+func influxSinkFromEnv(healthName string) (statslogdrain.MetricSink, error) {
+	config := statslogdrain.InfluxConfig{
+		URL:        os.Getenv("INFLUX_URL"),
+		Org:        os.Getenv("INFLUX_ORG"),
+		Bucket:     os.Getenv("INFLUX_BUCKET"),
+		HealthName: healthName,
+	}
+	if config.URL == "" {
+		return nil, errors.New("METRICS_SINK influx needs INFLUX_URL")
+	}
+
+	var err error
+	if config.Token, err = secretFromEnv("INFLUX_TOKEN"); err != nil {
+		return nil, err
+	}
+	if interval := os.Getenv("INFLUX_FLUSH_INTERVAL"); interval != "" {
+		if config.FlushInterval, err = time.ParseDuration(interval); err != nil {
+			return nil, fmt.Errorf("cannot parse INFLUX_FLUSH_INTERVAL: %v", err)
+		}
+	}
+	return statslogdrain.NewInfluxSink(config)
+}
+
+// untaggedSinkFromEnv returns the plain statsd or Graphite sink,
+// naming the metrics with METRIC_NAME_TEMPLATE
+func untaggedSinkFromEnv(kind, healthName string) (statslogdrain.MetricSink, error) {
+	template, err := statslogdrain.ParseNameTemplate(os.Getenv("METRIC_NAME_TEMPLATE"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse METRIC_NAME_TEMPLATE: %v", err)
+	}
+
+	if kind == "graphite" {
+		config := statslogdrain.GraphiteConfig{Address: os.Getenv("GRAPHITE_ADDRESS"), Template: template, HealthName: healthName}
+		if config.Address == "" {
+			return nil, errors.New("METRICS_SINK graphite needs GRAPHITE_ADDRESS")
+		}
+		if interval := os.Getenv("GRAPHITE_FLUSH_INTERVAL"); interval != "" {
+			if config.FlushInterval, err = time.ParseDuration(interval); err != nil {
+				return nil, fmt.Errorf("cannot parse GRAPHITE_FLUSH_INTERVAL: %v", err)
+			}
+		}
+		return statslogdrain.NewGraphiteSink(config)
+	}
+
+	statsdConfig, err := statsdConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return statslogdrain.NewPlainStatsdSink(statslogdrain.PlainStatsdConfig{
+		Address:       statsdConfig.Address,
+		Template:      template,
+		FlushInterval: statsdConfig.FlushInterval,
+		MaxPacketSize: statsdConfig.MaxPacketSize,
+		HealthName:    healthName,
+	})
+}
+
+// handleMetricsEndpoint serves exporter on /metrics, behind basic auth
+// with the user metrics and the password METRICS_PASSWORD, which is required
+func handleMetricsEndpoint(exporter http.Handler) error {
+	password, err := secretFromEnv("METRICS_PASSWORD")
+	if err != nil {
+		return err
+	}
+	if password == "" {
+		return errors.New("METRICS_SINK prometheus needs METRICS_PASSWORD")
+	}
+
+	scraper := statslogdrain.BasicAuthenticator{"metrics": password}
+	http.HandleFunc("/metrics", func(w http.ResponseWriter, req *http.Request) {
+		if _, valid := scraper.Authenticate(req); !valid {
+			http.Error(w, "Unauthorized", 401)
+			return
+		}
+		exporter.ServeHTTP(w, req)
+	})
+	return nil
+}
+
+// statsdConfigFromEnv reads the agent address from STATSD_ADDRESS
+// and its batching from STATSD_FLUSH_INTERVAL and STATSD_MAX_PACKET_SIZE
+func statsdConfigFromEnv() (statslogdrain.StatsdConfig, error) {
+	config := statslogdrain.StatsdConfig{Address: os.Getenv("STATSD_ADDRESS")}
+	var err error
+	if interval := os.Getenv("STATSD_FLUSH_INTERVAL"); interval != "" {
+		if config.FlushInterval, err = time.ParseDuration(interval); err != nil {
+			return config, fmt.Errorf("cannot parse STATSD_FLUSH_INTERVAL: %v", err)
+		}
+	}
+	if size := os.Getenv("STATSD_MAX_PACKET_SIZE"); size != "" {
+		if config.MaxPacketSize, err = strconv.Atoi(size); err != nil {
+			return config, fmt.Errorf("cannot parse STATSD_MAX_PACKET_SIZE: %v", err)
+		}
+	}
+	return config, nil
 }
 
-func userPasswordsFromEnv() map[string]string {
+func allowedAppsFromEnv() ([]string, error) {
 	allowedApps := os.Getenv("ALLOWED_APPS")
 	if allowedApps == "" {
-		log.Panic("Cannot start, ALLOWED_APPS not set")
+		if os.Getenv("REGISTRY_FILE") != "" {
+			return nil, nil
+		}
+		return nil, errors.New("ALLOWED_APPS not set")
+	}
+	return strings.Split(allowedApps, ","), nil
+}
+
+// registryFromEnv returns the registry of the apps in ALLOWED_APPS
+// and those added at runtime, persisted to REGISTRY_FILE
+func registryFromEnv(apps []string) (*statslogdrain.Registry, error) {
+	credentials, err := userCredentialsFromEnv(apps)
+	if err != nil {
+		return nil, err
+	}
+	registry, err := statslogdrain.NewRegistry(os.Getenv("REGISTRY_FILE"), credentials)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load REGISTRY_FILE: %v", err)
+	}
+	return registry, nil
+}
+
+func authenticatorFromEnv(apps []string, registry *statslogdrain.Registry) statslogdrain.Authenticator {
+	var authenticator statslogdrain.Authenticator = registry
+
+	required, _ := strconv.ParseBool(os.Getenv("REQUIRE_DRAIN_TOKENS"))
+	if tokens := drainTokensFromEnv(apps); len(tokens) > 0 || required {
+		authenticator = statslogdrain.NewDrainTokenAuthenticator(authenticator, tokens, required)
+	}
+	return authenticator
+}
+
+// setAuthLockoutFromEnv configures the lockout after AUTH_MAX_FAILURES failed
+// authentications within AUTH_FAILURE_WINDOW for AUTH_LOCKOUT, keeping the
+// defaults of what is unset
+func setAuthLockoutFromEnv() error {
+	maxFailures, window, lockout := os.Getenv("AUTH_MAX_FAILURES"), os.Getenv("AUTH_FAILURE_WINDOW"), os.Getenv("AUTH_LOCKOUT")
+	if maxFailures == "" && window == "" && lockout == "" {
+		return nil
 	}
 
-	passwords := make(map[string]string)
-	apps := strings.Split(allowedApps, ",")
+	failures, failureWindow, duration := statslogdrain.DefaultMaxAuthFailures, statslogdrain.DefaultAuthFailureWindow, statslogdrain.DefaultAuthLockout
+	var err error
+	if maxFailures != "" {
+		if failures, err = strconv.Atoi(maxFailures); err != nil {
+			return fmt.Errorf("cannot parse AUTH_MAX_FAILURES: %v", err)
+		}
+	}
+	if window != "" {
+		if failureWindow, err = time.ParseDuration(window); err != nil {
+			return fmt.Errorf("cannot parse AUTH_FAILURE_WINDOW: %v", err)
+		}
+	}
+	if lockout != "" {
+		if duration, err = time.ParseDuration(lockout); err != nil {
+			return fmt.Errorf("cannot parse AUTH_LOCKOUT: %v", err)
+		}
+	}
+	statslogdrain.SetAuthLockout(failures, failureWindow, duration)
+	return nil
+}
+
+// setTrustForwardedForFromEnv trusts X-Forwarded-For if TRUST_FORWARDED_FOR
+// is set, and otherwise on Heroku unless serving TLS without its router
+func setTrustForwardedForFromEnv(servesTLS bool) error {
+	trust := os.Getenv("DYNO") != "" && !servesTLS
+	if value := os.Getenv("TRUST_FORWARDED_FOR"); value != "" {
+		var err error
+		if trust, err = strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("cannot parse TRUST_FORWARDED_FOR: %v", err)
+		}
+	}
+	statslogdrain.SetTrustForwardedFor(trust)
+	return nil
+}
+
+func userCredentialsFromEnv(apps []string) (map[string][]statslogdrain.Credential, error) {
+	credentials := make(map[string][]statslogdrain.Credential)
 	for _, app := range apps {
 		passwordKey := fmt.Sprintf("%s_PASSWORD", strings.ToUpper(app))
-		password := os.Getenv(passwordKey)
-		if password == "" {
-			log.Panicf("Cannot find password, %s not set", passwordKey)
+		current, ok, err := credentialFromEnv("current", passwordKey)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("cannot find password for %s, set %s, %s_HASH or %s_FILE", app, passwordKey, passwordKey, passwordKey)
+		}
+		credentials[app] = []statslogdrain.Credential{current}
+
+		next, ok, err := credentialFromEnv("next", passwordKey+"_NEXT")
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			credentials[app] = append(credentials[app], next)
+		}
+	}
+
+	return credentials, nil
+}
+
+// drainTokensFromEnv maps the tokens listed in <APP>_DRAIN_TOKENS to their app
+func drainTokensFromEnv(apps []string) map[string]string {
+	tokens := make(map[string]string)
+	for _, app := range apps {
+		appTokens := os.Getenv(strings.ToUpper(app) + "_DRAIN_TOKENS")
+		if appTokens == "" {
+			continue
+		}
+		for _, token := range strings.Split(appTokens, ",") {
+			tokens[token] = app
 		}
-		passwords[app] = password
 	}
 
-	return passwords
+	return tokens
+}
+
+// credentialFromEnv reads a password from key or its hash from key_HASH
+func credentialFromEnv(name, key string) (statslogdrain.Credential, bool, error) {
+	password, err := secretFromEnv(key)
+	if err != nil {
+		return statslogdrain.Credential{}, false, err
+	}
+	hash, err := secretFromEnv(key + "_HASH")
+	if err != nil {
+		return statslogdrain.Credential{}, false, err
+	}
+	if hash != "" {
+		if err := statslogdrain.CheckPasswordHash(hash); err != nil {
+			return statslogdrain.Credential{}, false, fmt.Errorf("cannot use %s_HASH: %v", key, err)
+		}
+	}
+
+	credential := statslogdrain.Credential{Name: name, Password: password, Hash: hash}
+	return credential, password != "" || hash != "", nil
+}
+
+// hashPassword implements the hash-password subcommand, printing
+// a random password and its hash for use in <APP>_PASSWORD_HASH
+func hashPassword(args []string) {
+	flags := flag.NewFlagSet("hash-password", flag.ExitOnError)
+	algorithm := flags.String("algorithm", statslogdrain.Bcrypt, "hash algorithm, bcrypt or argon2id")
+	flags.Parse(args)
+
+	password, err := statslogdrain.GeneratePassword()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	hash, err := statslogdrain.HashPassword(*algorithm, password)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("password:", password)
+	fmt.Println("hash:    ", hash)
+}
+
+func appConfigsFromEnv(apps []string) (map[string]statslogdrain.AppConfig, error) {
+	configs := make(map[string]statslogdrain.AppConfig)
+	for _, app := range apps {
+		prefix := strings.ToUpper(app)
+		config := statslogdrain.AppConfig{
+			MetricsNamespace: os.Getenv(prefix + "_METRICS_NAMESPACE"),
+		}
+		if allowed := os.Getenv(prefix + "_ALLOWED_METRICS"); allowed != "" {
+			config.AllowedMetrics = strings.Split(allowed, ",")
+		}
+		if sources := os.Getenv(prefix + "_METRICS_SOURCES"); sources != "" {
+			for _, s := range strings.Split(sources, ",") {
+				source, err := statslogdrain.ParseMetricSource(s)
+				if err != nil {
+					return nil, fmt.Errorf("cannot parse %s_METRICS_SOURCES entry %q: %v", prefix, s, err)
+				}
+				config.MetricSources = append(config.MetricSources, source)
+			}
+		}
+
+		var err error
+		if config.CounterRules, err = counterRulesFromEnv(prefix + "_LOG_COUNTER_"); err != nil {
+			return nil, err
+		}
+		if config.LinesPerSecond, err = floatFromEnv(prefix + "_LINES_PER_SECOND"); err != nil {
+			return nil, err
+		}
+		if config.MetricsPerSecond, err = floatFromEnv(prefix + "_METRICS_PER_SECOND"); err != nil {
+			return nil, err
+		}
+		switch mode := os.Getenv(prefix + "_RATE_LIMIT_MODE"); mode {
+		case "", "sample":
+		case "reject":
+			config.RejectOverLimit = true
+		default:
+			return nil, fmt.Errorf("cannot parse %s_RATE_LIMIT_MODE %q, expected reject or sample", prefix, mode)
+		}
+		if tags := os.Getenv(prefix + "_TAGS"); tags != "" {
+			config.Tags = strings.Split(tags, ",")
+		}
+		sink, err := appSinkFromEnv(app)
+		if err != nil {
+			return nil, err
+		}
+		if sink != nil {
+			// a fanout to the single sink records its errors under its name instead of default
+			fanout := statslogdrain.NewFanoutSink()
+			fanout.Add(app+"/"+os.Getenv(prefix+"_METRICS_SINK"), sink, statslogdrain.SinkFilter{})
+			config.Sink = fanout
+		}
+		configs[app] = config
+	}
+
+	return configs, nil
+}
+
+// appSinkFromEnv returns the sink of kind <APP-NAME>_METRICS_SINK sending
+// only the app's metrics, configured and aggregated like the sinks of
+// METRICS_SINK, or nil. It is named <app-name>/<kind> in /healthz.
+func appSinkFromEnv(app string) (statslogdrain.MetricSink, error) {
+	prefix := strings.ToUpper(app)
+	kind := os.Getenv(prefix + "_METRICS_SINK")
+	if kind == "" {
+		return nil, nil
+	}
+	apiKey, err := secretFromEnv("DD_API_KEY")
+	if err != nil {
+		return nil, err
+	}
+	if kind == "prometheus" {
+		return nil, fmt.Errorf("%s_METRICS_SINK cannot be prometheus, which is served on /metrics for all apps", prefix)
+	}
+	sink, err := sinkFromEnv(kind, app+"/"+kind, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	return aggregatedFromEnv(sink, prefix+"_METRICS_SINK", app+"/aggregating")
+}
+
+func counterRulesFromEnv(prefix string) ([]statslogdrain.CounterRule, error) {
+	rules := []statslogdrain.CounterRule{}
+	for _, env := range os.Environ() {
+		keyValue := strings.SplitN(env, "=", 2)
+		if !strings.HasPrefix(keyValue[0], prefix) {
+			continue
+		}
+		rule, err := statslogdrain.ParseCounterRule(strings.TrimPrefix(keyValue[0], prefix), keyValue[1])
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse %s: %v", keyValue[0], err)
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+func floatFromEnv(key string) (float64, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse %s: %v", key, err)
+	}
+	return f, nil
 }
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/mat/heroku-datadog-drain-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricSinkFromEnv(t *testing.T) {
+	sink, exporter, err := metricSinkFromEnv()
+	assert.NoError(t, err)
+	assert.IsType(t, &statsd.Client{}, sink)
+	assert.Nil(t, exporter)
+
+	t.Setenv("DD_API_KEY", "secret")
+	sink, _, err = metricSinkFromEnv()
+	assert.NoError(t, err)
+	assert.IsType(t, &statsd.Client{}, sink)
+	t.Setenv("DD_API_KEY", "")
+
+	t.Setenv("METRICS_SINK", "prometheus")
+	sink, exporter, err = metricSinkFromEnv()
+	assert.NoError(t, err)
+	assert.IsType(t, &statslogdrain.PrometheusSink{}, sink)
+	assert.Equal(t, sink, exporter)
+
+	t.Setenv("METRICS_SINK", "datadog-api")
+	_, _, err = metricSinkFromEnv()
+	assert.EqualError(t, err, "METRICS_SINK datadog-api needs DD_API_KEY")
+
+	t.Setenv("METRICS_SINK", "librato")
+	_, _, err = metricSinkFromEnv()
+	assert.EqualError(t, err, `cannot parse METRICS_SINK "librato", expected statsd, datadog-api, prometheus, otlp, influx, statsd-plain or graphite`)
+
+	t.Setenv("METRICS_SINK", "otlp")
+	_, _, err = metricSinkFromEnv()
+	assert.EqualError(t, err, "METRICS_SINK otlp needs OTEL_EXPORTER_OTLP_ENDPOINT")
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318")
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "api-key=secret")
+	sink, _, err = metricSinkFromEnv()
+	assert.NoError(t, err)
+	assert.IsType(t, &statslogdrain.OTLPSink{}, sink)
+	sink.(*statslogdrain.OTLPSink).Close()
+
+	t.Setenv("METRICS_SINK", "influx")
+	t.Setenv("INFLUX_URL", "stdout")
+	sink, _, err = metricSinkFromEnv()
+	assert.NoError(t, err)
+	assert.IsType(t, &statslogdrain.InfluxSink{}, sink)
+	sink.(*statslogdrain.InfluxSink).Close()
+
+	t.Setenv("METRICS_SINK", "graphite")
+	t.Setenv("METRIC_NAME_TEMPLATE", "heroku.{app}.{name:1")
+	_, _, err = metricSinkFromEnv()
+	assert.EqualError(t, err, `cannot parse METRIC_NAME_TEMPLATE: unbalanced braces in name template "heroku.{app}.{name:1"`)
+
+	t.Setenv("METRIC_NAME_TEMPLATE", "heroku.{app}.{name:1}")
+	_, _, err = metricSinkFromEnv()
+	assert.EqualError(t, err, "METRICS_SINK graphite needs GRAPHITE_ADDRESS")
+
+	t.Setenv("METRICS_SINK", "statsd-plain")
+	sink, _, err = metricSinkFromEnv()
+	assert.NoError(t, err)
+	assert.IsType(t, &statslogdrain.PlainStatsdSink{}, sink)
+	sink.(*statslogdrain.PlainStatsdSink).Close()
+
+	t.Setenv("METRICS_SINK", "statsd,prometheus")
+	sink, exporter, err = metricSinkFromEnv()
+	assert.NoError(t, err)
+	assert.IsType(t, &statslogdrain.FanoutSink{}, sink)
+	assert.IsType(t, &statslogdrain.PrometheusSink{}, exporter)
+
+	t.Setenv("METRICS_SINK", "statsd")
+	t.Setenv("STATSD_EXCLUDE_APPS", "staging-app")
+	sink, _, err = metricSinkFromEnv()
+	assert.NoError(t, err)
+	assert.IsType(t, &statslogdrain.FanoutSink{}, sink)
+
+	t.Setenv("METRICS_SINK", "statsd,statsd")
+	_, _, err = metricSinkFromEnv()
+	assert.EqualError(t, err, "METRICS_SINK lists statsd twice")
+}
+
+func TestAggregatedFromEnv(t *testing.T) {
+	stub := &statsd.NoOpClient{}
+	sink, err := aggregatedFromEnv(stub, "METRICS_SINK", "aggregating")
+	assert.NoError(t, err)
+	assert.Equal(t, stub, sink)
+
+	t.Setenv("AGGREGATE_METRICS", "1")
+	t.Setenv("AGGREGATION_INTERVAL", "10")
+	_, err = aggregatedFromEnv(stub, "METRICS_SINK", "aggregating")
+	assert.EqualError(t, err, `cannot parse AGGREGATION_INTERVAL: time: missing unit in duration "10"`)
+
+	t.Setenv("AGGREGATION_INTERVAL", "1m")
+	sink, err = aggregatedFromEnv(stub, "METRICS_SINK", "aggregating")
+	assert.NoError(t, err)
+	assert.IsType(t, &statslogdrain.AggregatingSink{}, sink)
+	sink.(*statslogdrain.AggregatingSink).Close()
+
+	t.Setenv("METRICS_SINK", "statsd, influx")
+	_, err = aggregatedFromEnv(stub, "METRICS_SINK", "aggregating")
+	assert.EqualError(t, err, "AGGREGATE_METRICS cannot be combined with METRICS_SINK influx, which records when lines were logged")
+}
+
+func TestMetricsEndpointNeedsPassword(t *testing.T) {
+	t.Setenv("METRICS_PASSWORD", "")
+	err := handleMetricsEndpoint(statslogdrain.NewPrometheusSink(statslogdrain.PrometheusConfig{}))
+	assert.EqualError(t, err, "METRICS_SINK prometheus needs METRICS_PASSWORD")
+}
+
+func TestSinkFilterFromEnv(t *testing.T) {
+	t.Setenv("DATADOG_API_INCLUDE_METRICS", "heroku.router.*,heroku.dyno.*")
+	t.Setenv("DATADOG_API_EXCLUDE_APPS", "staging-app")
+	assert.Equal(t, statslogdrain.SinkFilter{
+		IncludeMetrics: []string{"heroku.router.*", "heroku.dyno.*"},
+		ExcludeApps:    []string{"staging-app"},
+	}, sinkFilterFromEnv("datadog-api"))
+}
+
+func TestAppConfigsFromEnvTagsAndSink(t *testing.T) {
+	t.Setenv("TEST-APP_TAGS", "team:payments,tier:1")
+	t.Setenv("TEST-APP_METRICS_SINK", "statsd")
+	configs, err := appConfigsFromEnv([]string{"test-app"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"team:payments", "tier:1"}, configs["test-app"].Tags)
+	assert.Equal(t, map[string]int64{"test-app/statsd": 0}, configs["test-app"].Sink.(*statslogdrain.FanoutSink).Errors())
+	sink, err := appSinkFromEnv("test-app")
+	assert.NoError(t, err)
+	assert.IsType(t, &statsd.Client{}, sink)
+
+	t.Setenv("AGGREGATE_METRICS", "1")
+	sink, err = appSinkFromEnv("test-app")
+	assert.NoError(t, err)
+	assert.IsType(t, &statslogdrain.AggregatingSink{}, sink)
+	sink.(*statslogdrain.AggregatingSink).Close()
+
+	t.Setenv("TEST-APP_METRICS_SINK", "influx")
+	t.Setenv("INFLUX_URL", "stdout")
+	_, err = appConfigsFromEnv([]string{"test-app"})
+	assert.EqualError(t, err, "AGGREGATE_METRICS cannot be combined with TEST-APP_METRICS_SINK influx, which records when lines were logged")
+	t.Setenv("AGGREGATE_METRICS", "0")
+
+	t.Setenv("TEST-APP_METRICS_SINK", "prometheus")
+	_, err = appConfigsFromEnv([]string{"test-app"})
+	assert.EqualError(t, err, "TEST-APP_METRICS_SINK cannot be prometheus, which is served on /metrics for all apps")
+}
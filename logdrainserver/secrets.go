@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// secretFromEnv looks up the secret key, in order of precedence, in
+//
+//	the environment variable key
+//	the file named by the environment variable key_FILE, like Docker secrets
+//	the file key in the directory SECRETS_DIR, e.g. /run/secrets/MY-APP_PASSWORD
+//	the KEY=value lines of the file SECRETS_FILE
+//
+// It returns "" if the secret is set nowhere.
+func secretFromEnv(key string) (string, error) {
+	if value := os.Getenv(key); value != "" {
+		return value, nil
+	}
+
+	if path := os.Getenv(key + "_FILE"); path != "" {
+		secret, err := readSecretFile(path)
+		if err != nil {
+			return "", fmt.Errorf("cannot read %s_FILE: %v", key, err)
+		}
+		return secret, nil
+	}
+
+	if dir := os.Getenv("SECRETS_DIR"); dir != "" {
+		secret, err := readSecretFile(filepath.Join(dir, key))
+		if err == nil {
+			return secret, nil
+		} else if !os.IsNotExist(err) {
+			return "", fmt.Errorf("cannot read %s from SECRETS_DIR: %v", key, err)
+		}
+	}
+
+	if path := os.Getenv("SECRETS_FILE"); path != "" {
+		secrets, err := loadSecretsFile(path)
+		if err != nil {
+			return "", fmt.Errorf("cannot read SECRETS_FILE: %v", err)
+		}
+		return secrets[key], nil
+	}
+
+	return "", nil
+}
+
+// readSecretFile reads a secret from a file of its own, ignoring a trailing newline
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimRight(string(data), "\r\n")
+	if secret == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return secret, nil
+}
+
+var (
+	secretsFiles      = map[string]map[string]string{}
+	secretsFilesMutex sync.Mutex
+)
+
+// loadSecretsFile parses a file of KEY=value lines, skipping
+// blank lines and # comments, and caches its secrets by path
+func loadSecretsFile(path string) (map[string]string, error) {
+	secretsFilesMutex.Lock()
+	defer secretsFilesMutex.Unlock()
+	if secrets, ok := secretsFiles[path]; ok {
+		return secrets, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	secrets := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keyValue := strings.SplitN(line, "=", 2)
+		if len(keyValue) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected KEY=value", path, lineNumber)
+		}
+		secrets[strings.TrimSpace(keyValue[0])] = strings.TrimSpace(keyValue[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	secretsFiles[path] = secrets
+	return secrets, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecretFromEnv(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "password"), []byte("from-file\n"), 0600)
+	os.WriteFile(filepath.Join(dir, "MY-APP_PASSWORD_NEXT"), []byte("from-dir"), 0600)
+	os.WriteFile(filepath.Join(dir, "secrets.env"), []byte("# drain secrets\nOTHER-APP_PASSWORD = from-secrets-file\n\nADMIN_PASSWORD=admin\n"), 0600)
+
+	t.Setenv("MY-APP_PASSWORD", "from-env")
+	t.Setenv("MY-APP_PASSWORD_HASH_FILE", filepath.Join(dir, "password"))
+	t.Setenv("SECRETS_DIR", dir)
+	t.Setenv("SECRETS_FILE", filepath.Join(dir, "secrets.env"))
+
+	for key, expected := range map[string]string{
+		"MY-APP_PASSWORD":      "from-env",
+		"MY-APP_PASSWORD_HASH": "from-file",
+		"MY-APP_PASSWORD_NEXT": "from-dir",
+		"OTHER-APP_PASSWORD":   "from-secrets-file",
+		"UNKNOWN_PASSWORD":     "",
+	} {
+		secret, err := secretFromEnv(key)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, secret, key)
+	}
+
+	t.Setenv("BROKEN_PASSWORD_FILE", filepath.Join(dir, "missing"))
+	_, err := secretFromEnv("BROKEN_PASSWORD")
+	assert.EqualError(t, err, "cannot read BROKEN_PASSWORD_FILE: open "+filepath.Join(dir, "missing")+": no such file or directory")
+}
+
+func TestUserCredentialsFromEnvErrors(t *testing.T) {
+	_, err := userCredentialsFromEnv([]string{"missing-app"})
+	assert.EqualError(t, err, "cannot find password for missing-app, set MISSING-APP_PASSWORD, MISSING-APP_PASSWORD_HASH or MISSING-APP_PASSWORD_FILE")
+
+	t.Setenv("HASHED-APP_PASSWORD_HASH", "deadbeef")
+	_, err = userCredentialsFromEnv([]string{"hashed-app"})
+	assert.EqualError(t, err, "cannot use HASHED-APP_PASSWORD_HASH: unknown password hash format, expected bcrypt or argon2")
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// certCheckInterval is how often the certificate files are checked for changes
+const certCheckInterval = 10 * time.Second
+
+// certReloader serves a certificate and key pair, loading
+// them again whenever either file changes on disk
+type certReloader struct {
+	sync.Mutex
+	certFile, keyFile string
+	cert              *tls.Certificate
+	modTime           time.Time
+	checked           time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(time.Now()); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if now := time.Now(); now.Sub(r.checked) >= certCheckInterval {
+		if err := r.reload(now); err != nil {
+			log.Println("error reloading TLS certificate, keeping the current one:", err)
+		}
+	}
+	return r.cert, nil
+}
+
+// reload loads the certificate unless its files are unchanged. The caller must hold the lock.
+func (r *certReloader) reload(now time.Time) error {
+	r.checked = now
+	modTime, err := latestModTime(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	if r.cert != nil && modTime.Equal(r.modTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	if r.cert != nil {
+		log.Println("Reloaded TLS certificate", r.certFile)
+	}
+	r.cert, r.modTime = &cert, modTime
+	return nil
+}
+
+func latestModTime(paths ...string) (time.Time, error) {
+	var latest time.Time
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsConfigFromEnv returns the TLS config for TLS_CERT_FILE and TLS_KEY_FILE
+// with TLS_MIN_VERSION, default 1.2, or nil to serve plain HTTP
+func tlsConfigFromEnv() (*tls.Config, error) {
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("TLS needs both TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+
+	minVersion := uint16(tls.VersionTLS12)
+	if version := os.Getenv("TLS_MIN_VERSION"); version != "" {
+		var ok bool
+		if minVersion, ok = tlsVersions[version]; !ok {
+			return nil, fmt.Errorf("cannot parse TLS_MIN_VERSION %q, expected 1.0, 1.1, 1.2 or 1.3", version)
+		}
+	}
+
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load TLS certificate: %v", err)
+	}
+	return &tls.Config{MinVersion: minVersion, GetCertificate: reloader.GetCertificate}, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestCertificate(t *testing.T, certFile, keyFile, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+}
+
+func commonName(t *testing.T, cert *tls.Certificate) string {
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	assert.NoError(t, err)
+	return parsed.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestCertificate(t, certFile, keyFile, "first")
+
+	reloader, err := newCertReloader(certFile, keyFile)
+	assert.NoError(t, err)
+	cert, _ := reloader.GetCertificate(nil)
+	assert.Equal(t, "first", commonName(t, cert))
+
+	writeTestCertificate(t, certFile, keyFile, "second")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+
+	cert, _ = reloader.GetCertificate(nil)
+	assert.Equal(t, "first", commonName(t, cert), "files are not checked again before certCheckInterval")
+
+	reloader.checked = time.Time{}
+	cert, _ = reloader.GetCertificate(nil)
+	assert.Equal(t, "second", commonName(t, cert))
+
+	os.WriteFile(keyFile, []byte("broken"), 0600)
+	os.Chtimes(keyFile, later.Add(time.Minute), later.Add(time.Minute))
+	reloader.checked = time.Time{}
+	cert, _ = reloader.GetCertificate(nil)
+	assert.Equal(t, "second", commonName(t, cert), "keeps the current certificate when reloading fails")
+}
+
+func TestTLSConfigFromEnv(t *testing.T) {
+	config, err := tlsConfigFromEnv()
+	assert.NoError(t, err)
+	assert.Nil(t, config)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestCertificate(t, certFile, keyFile, "drain")
+
+	t.Setenv("TLS_CERT_FILE", certFile)
+	_, err = tlsConfigFromEnv()
+	assert.EqualError(t, err, "TLS needs both TLS_CERT_FILE and TLS_KEY_FILE")
+
+	t.Setenv("TLS_KEY_FILE", keyFile)
+	config, err = tlsConfigFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), config.MinVersion)
+
+	t.Setenv("TLS_MIN_VERSION", "1.3")
+	config, err = tlsConfigFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), config.MinVersion)
+
+	t.Setenv("TLS_MIN_VERSION", "TLS1.3")
+	_, err = tlsConfigFromEnv()
+	assert.EqualError(t, err, `cannot parse TLS_MIN_VERSION "TLS1.3", expected 1.0, 1.1, 1.2 or 1.3`)
+}
+
+func TestSetTrustForwardedForFromEnv(t *testing.T) {
+	t.Setenv("TRUST_FORWARDED_FOR", "maybe")
+	assert.EqualError(t, setTrustForwardedForFromEnv(false), `cannot parse TRUST_FORWARDED_FOR: strconv.ParseBool: parsing "maybe": invalid syntax`)
+	t.Setenv("TRUST_FORWARDED_FOR", "true")
+	assert.NoError(t, setTrustForwardedForFromEnv(true))
+	t.Setenv("TRUST_FORWARDED_FOR", "")
+	assert.NoError(t, setTrustForwardedForFromEnv(false))
+}
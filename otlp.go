@@ -0,0 +1,413 @@
+package statslogdrain
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+)
+
+// OTLPConfig configures the sink exporting to an OpenTelemetry collector
+type OTLPConfig struct {
+	// Endpoint is the collector's OTLP/HTTP URL, e.g. http://localhost:4318,
+	// with /v1/metrics appended unless it has a path
+	Endpoint string
+	// Headers are sent with every request, e.g. for authentication
+	Headers map[string]string
+	// FlushInterval is how often the aggregated metrics are exported, default 10s
+	FlushInterval time.Duration
+	// Buckets are the explicit histogram bounds, default DefaultPrometheusBuckets
+	Buckets []float64
+	// MaxRetries is how often a failed export is retried, default 3
+	MaxRetries int
+	// HTTPClient sends the requests, default a client with a 10s timeout
+	HTTPClient *http.Client
+	// HealthName names the sink in /healthz, default otlp
+	HealthName string
+}
+
+// otlpResourceTags are the tags exported as resource attributes instead of data point attributes
+var otlpResourceTags = []string{"app", "dyno", "process_type"}
+
+// OTLPSink aggregates metrics in-process and exports them as OTLP/HTTP JSON
+// with delta temporality. Histograms and timings become explicit bucket
+// histograms, counts monotonic sums and gauges and sets gauges. The app,
+// dyno and process type become resource attributes, with service.name set
+// to the app. Service checks are exported as gauges of their status and
+// events counted as heroku.events.
+type OTLPSink struct {
+	mutex        sync.Mutex
+	config       OTLPConfig
+	url          string
+	points       map[string]*otlpPoint
+	start        time.Time
+	retryBackoff time.Duration
+	flushLoop    *flushLoop
+}
+
+type otlpPoint struct {
+	name       string
+	kind       aggregateKind
+	resource   [][2]string
+	attributes [][2]string
+	value      float64
+	buckets    []uint64
+	sum        float64
+	count      uint64
+	min, max   float64
+	set        map[string]bool
+}
+
+// NewOTLPSink returns a sink for config, exporting until closed
+func NewOTLPSink(config OTLPConfig) (*OTLPSink, error) {
+	if config.Endpoint == "" {
+		return nil, fmt.Errorf("OTLP sink needs an endpoint")
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = 10 * time.Second
+	}
+	if config.HealthName == "" {
+		config.HealthName = "otlp"
+	}
+	if len(config.Buckets) == 0 {
+		config.Buckets = DefaultPrometheusBuckets
+	}
+	if config.MaxRetries <= 0 {
+		config.MaxRetries = 3
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	sink := &OTLPSink{
+		config:       config,
+		url:          otlpMetricsURL(config.Endpoint),
+		points:       make(map[string]*otlpPoint),
+		start:        time.Now(),
+		retryBackoff: time.Second,
+	}
+	sink.flushLoop = startFlushLoop(config.HealthName, config.FlushInterval, sink.Flush)
+	return sink, nil
+}
+
+func otlpMetricsURL(endpoint string) string {
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	if i := strings.Index(endpoint, "://"); i >= 0 && strings.Contains(endpoint[i+3:], "/") {
+		return endpoint
+	}
+	return endpoint + "/v1/metrics"
+}
+
+// Close stops exporting periodically and exports what was aggregated since the last flush
+func (s *OTLPSink) Close() error {
+	s.flushLoop.stop()
+	return s.Flush()
+}
+
+// splitOTLPTags splits key:value tags into resource and data point attributes
+func splitOTLPTags(tags []string) (resource, attributes [][2]string) {
+	for _, tag := range tags {
+		keyValue := strings.SplitN(tag, ":", 2)
+		if len(keyValue) == 1 {
+			keyValue = append(keyValue, "")
+		}
+		attribute := [2]string{keyValue[0], keyValue[1]}
+		if containsString(otlpResourceTags, attribute[0]) {
+			resource = append(resource, attribute)
+		} else {
+			attributes = append(attributes, attribute)
+		}
+	}
+
+	if !hasAttribute(resource, "process_type") {
+		for _, attribute := range resource {
+			if attribute[0] == "dyno" {
+				processType := strings.SplitN(attribute[1], ".", 2)[0]
+				resource = append(resource, [2]string{"process_type", processType})
+				break
+			}
+		}
+	}
+	sortAttributes(resource)
+	sortAttributes(attributes)
+	return resource, attributes
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAttribute(attributes [][2]string, key string) bool {
+	for _, attribute := range attributes {
+		if attribute[0] == key {
+			return true
+		}
+	}
+	return false
+}
+
+func sortAttributes(attributes [][2]string) {
+	sort.Slice(attributes, func(i, j int) bool { return attributes[i][0] < attributes[j][0] })
+}
+
+func attributesKey(attributes [][2]string) string {
+	parts := make([]string, 0, len(attributes))
+	for _, attribute := range attributes {
+		parts = append(parts, attribute[0]+"="+attribute[1])
+	}
+	return strings.Join(parts, ",")
+}
+
+func (s *OTLPSink) point(name string, kind aggregateKind, tags []string) *otlpPoint {
+	resource, attributes := splitOTLPTags(tags)
+	key := fmt.Sprintf("%s|%s|%d|%s", attributesKey(resource), name, kind, attributesKey(attributes))
+	p, ok := s.points[key]
+	if !ok {
+		p = &otlpPoint{name: name, kind: kind, resource: resource, attributes: attributes}
+		if kind == kindHistogram {
+			p.buckets = make([]uint64, len(s.config.Buckets)+1)
+		}
+		s.points[key] = p
+	}
+	return p
+}
+
+func (s *OTLPSink) Gauge(name string, value float64, tags []string, rate float64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.point(name, kindGauge, tags).value = value
+	return nil
+}
+
+func (s *OTLPSink) Count(name string, value int64, tags []string, rate float64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.point(name, kindCount, tags).value += float64(value)
+	return nil
+}
+
+func (s *OTLPSink) Histogram(name string, value float64, tags []string, rate float64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	p := s.point(name, kindHistogram, tags)
+	bucket := sort.SearchFloat64s(s.config.Buckets, value)
+	p.buckets[bucket]++
+	if p.count == 0 || value < p.min {
+		p.min = value
+	}
+	if p.count == 0 || value > p.max {
+		p.max = value
+	}
+	p.sum += value
+	p.count++
+	return nil
+}
+
+func (s *OTLPSink) Set(name string, value string, tags []string, rate float64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	p := s.point(name, kindSet, tags)
+	if p.set == nil {
+		p.set = make(map[string]bool)
+	}
+	p.set[value] = true
+	return nil
+}
+
+func (s *OTLPSink) TimeInMilliseconds(name string, value float64, tags []string, rate float64) error {
+	return s.Histogram(name, value, tags, rate)
+}
+
+func (s *OTLPSink) Event(e *statsd.Event) error {
+	return s.Count("heroku.events", 1, e.Tags, 1)
+}
+
+func (s *OTLPSink) ServiceCheck(sc *statsd.ServiceCheck) error {
+	return s.Gauge(sc.Name+".status", float64(sc.Status), sc.Tags, 1)
+}
+
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpAttribute struct {
+	Key   string            `json:"key"`
+	Value map[string]string `json:"value"`
+}
+
+type otlpMetric struct {
+	Name      string         `json:"name"`
+	Sum       *otlpSum       `json:"sum,omitempty"`
+	Gauge     *otlpGauge     `json:"gauge,omitempty"`
+	Histogram *otlpHistogram `json:"histogram,omitempty"`
+}
+
+// otlpDeltaTemporality is AGGREGATION_TEMPORALITY_DELTA
+const otlpDeltaTemporality = 1
+
+type otlpSum struct {
+	DataPoints             []otlpNumberDataPoint `json:"dataPoints"`
+	AggregationTemporality int                   `json:"aggregationTemporality"`
+	IsMonotonic            bool                  `json:"isMonotonic"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberDataPoint `json:"dataPoints"`
+}
+
+type otlpHistogram struct {
+	DataPoints             []otlpHistogramDataPoint `json:"dataPoints"`
+	AggregationTemporality int                      `json:"aggregationTemporality"`
+}
+
+// Timestamps and counts are 64 bit integers, which OTLP/JSON encodes as strings
+
+type otlpNumberDataPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	AsDouble          float64         `json:"asDouble"`
+}
+
+type otlpHistogramDataPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	Count             string          `json:"count"`
+	Sum               float64         `json:"sum"`
+	BucketCounts      []string        `json:"bucketCounts"`
+	ExplicitBounds    []float64       `json:"explicitBounds"`
+	Min               float64         `json:"min"`
+	Max               float64         `json:"max"`
+}
+
+func toOTLPAttributes(attributes [][2]string) []otlpAttribute {
+	result := make([]otlpAttribute, 0, len(attributes))
+	for _, attribute := range attributes {
+		result = append(result, otlpAttribute{Key: attribute[0], Value: map[string]string{"stringValue": attribute[1]}})
+	}
+	return result
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// Flush exports the metrics aggregated since the last flush
+func (s *OTLPSink) Flush() error {
+	s.mutex.Lock()
+	points, start := s.points, s.start
+	s.points, s.start = make(map[string]*otlpPoint), time.Now()
+	s.mutex.Unlock()
+
+	if len(points) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(s.toRequest(points, start, time.Now()))
+	if err != nil {
+		return err
+	}
+
+	headers := map[string]string{"Content-Type": "application/json"}
+	for key, value := range s.config.Headers {
+		headers[key] = value
+	}
+	return postWithRetries(s.config.HTTPClient, s.url, headers, payload, s.config.MaxRetries, s.retryBackoff)
+}
+
+func (s *OTLPSink) toRequest(points map[string]*otlpPoint, start, now time.Time) otlpRequest {
+	keys := make([]string, 0, len(points))
+	for key := range points {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	request := otlpRequest{ResourceMetrics: []otlpResourceMetrics{}}
+	var resourceMetrics *otlpResourceMetrics
+	var resourceKey string
+	for _, key := range keys {
+		p := points[key]
+		if resourceMetrics == nil || attributesKey(p.resource) != resourceKey {
+			resourceKey = attributesKey(p.resource)
+			attributes := toOTLPAttributes(p.resource)
+			for _, attribute := range p.resource {
+				if attribute[0] == "app" {
+					attributes = append(attributes, otlpAttribute{Key: "service.name", Value: map[string]string{"stringValue": attribute[1]}})
+				}
+			}
+			request.ResourceMetrics = append(request.ResourceMetrics, otlpResourceMetrics{
+				Resource:     otlpResource{Attributes: attributes},
+				ScopeMetrics: []otlpScopeMetrics{{Scope: otlpScope{Name: "heroku-datadog-drain-go"}}},
+			})
+			resourceMetrics = &request.ResourceMetrics[len(request.ResourceMetrics)-1]
+		}
+
+		scope := &resourceMetrics.ScopeMetrics[0]
+		scope.Metrics = append(scope.Metrics, s.toMetric(p, start, now))
+	}
+	return request
+}
+
+func (s *OTLPSink) toMetric(p *otlpPoint, start, now time.Time) otlpMetric {
+	attributes := toOTLPAttributes(p.attributes)
+	number := func(value float64) []otlpNumberDataPoint {
+		return []otlpNumberDataPoint{{Attributes: attributes, StartTimeUnixNano: unixNano(start), TimeUnixNano: unixNano(now), AsDouble: value}}
+	}
+
+	switch p.kind {
+	case kindCount:
+		return otlpMetric{Name: p.name, Sum: &otlpSum{DataPoints: number(p.value), AggregationTemporality: otlpDeltaTemporality, IsMonotonic: true}}
+	case kindSet:
+		return otlpMetric{Name: p.name, Gauge: &otlpGauge{DataPoints: number(float64(len(p.set)))}}
+	case kindHistogram:
+		buckets := make([]string, 0, len(p.buckets))
+		for _, count := range p.buckets {
+			buckets = append(buckets, strconv.FormatUint(count, 10))
+		}
+		return otlpMetric{Name: p.name, Histogram: &otlpHistogram{
+			DataPoints: []otlpHistogramDataPoint{{
+				Attributes:        attributes,
+				StartTimeUnixNano: unixNano(start),
+				TimeUnixNano:      unixNano(now),
+				Count:             strconv.FormatUint(p.count, 10),
+				Sum:               p.sum,
+				BucketCounts:      buckets,
+				ExplicitBounds:    s.config.Buckets,
+				Min:               p.min,
+				Max:               p.max,
+			}},
+			AggregationTemporality: otlpDeltaTemporality,
+		}}
+	default:
+		return otlpMetric{Name: p.name, Gauge: &otlpGauge{DataPoints: number(p.value)}}
+	}
+}
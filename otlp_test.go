@@ -0,0 +1,68 @@
+package statslogdrain
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOTLPSink(t *testing.T) {
+	requests := []otlpRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/v1/metrics", req.URL.Path)
+		assert.Equal(t, "Bearer token", req.Header.Get("Authorization"))
+		var request otlpRequest
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(&request))
+		requests = append(requests, request)
+	}))
+	defer server.Close()
+
+	sink, err := NewOTLPSink(OTLPConfig{Endpoint: server.URL, Headers: map[string]string{"Authorization": "Bearer token"}, FlushInterval: time.Hour, Buckets: []float64{10, 100}})
+	assert.NoError(t, err)
+
+	web := []string{"dyno:web.1", "status:200", "app:test-app"}
+	sink.Histogram("heroku.router.request.service", 5, web, 1)
+	sink.Histogram("heroku.router.request.service", 50, web, 1)
+	sink.Histogram("heroku.router.request.service", 500, web, 1)
+	sink.Count("heroku.dyno.exit", 1, []string{"dyno:worker.2", "process_type:worker", "cause:crash", "app:test-app"}, 1)
+	sink.Count("heroku.dyno.exit", 1, []string{"dyno:worker.2", "process_type:worker", "cause:crash", "app:test-app"}, 1)
+	assert.NoError(t, sink.Close())
+
+	assert.Len(t, requests, 1)
+	resources := requests[0].ResourceMetrics
+	assert.Len(t, resources, 2)
+
+	assert.Equal(t, []otlpAttribute{
+		{Key: "app", Value: map[string]string{"stringValue": "test-app"}},
+		{Key: "dyno", Value: map[string]string{"stringValue": "web.1"}},
+		{Key: "process_type", Value: map[string]string{"stringValue": "web"}},
+		{Key: "service.name", Value: map[string]string{"stringValue": "test-app"}},
+	}, resources[0].Resource.Attributes)
+	histogram := resources[0].ScopeMetrics[0].Metrics[0]
+	assert.Equal(t, "heroku.router.request.service", histogram.Name)
+	point := histogram.Histogram.DataPoints[0]
+	assert.Equal(t, []otlpAttribute{{Key: "status", Value: map[string]string{"stringValue": "200"}}}, point.Attributes)
+	assert.Equal(t, "3", point.Count)
+	assert.Equal(t, 555.0, point.Sum)
+	assert.Equal(t, []string{"1", "1", "1"}, point.BucketCounts)
+	assert.Equal(t, []float64{10, 100}, point.ExplicitBounds)
+	assert.Equal(t, 5.0, point.Min)
+	assert.Equal(t, 500.0, point.Max)
+	assert.Equal(t, otlpDeltaTemporality, histogram.Histogram.AggregationTemporality)
+
+	sum := resources[1].ScopeMetrics[0].Metrics[0]
+	assert.Equal(t, "heroku.dyno.exit", sum.Name)
+	assert.True(t, sum.Sum.IsMonotonic)
+	assert.Equal(t, 2.0, sum.Sum.DataPoints[0].AsDouble)
+	assert.Equal(t, []otlpAttribute{{Key: "cause", Value: map[string]string{"stringValue": "crash"}}}, sum.Sum.DataPoints[0].Attributes)
+}
+
+func TestOTLPMetricsURL(t *testing.T) {
+	assert.Equal(t, "http://localhost:4318/v1/metrics", otlpMetricsURL("http://localhost:4318"))
+	assert.Equal(t, "http://localhost:4318/v1/metrics", otlpMetricsURL("http://localhost:4318/"))
+	assert.Equal(t, "https://otlp.example.com/otlp/v1/metrics", otlpMetricsURL("https://otlp.example.com/otlp/v1/metrics"))
+}
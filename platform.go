@@ -0,0 +1,172 @@
+package statslogdrain
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+)
+
+// Exit causes tagged on heroku.dyno.exit
+const (
+	causeCycling   = "cycling"
+	causeDeploy    = "deploy"
+	causeRestart   = "restart"
+	causeScaleDown = "scale_down"
+	causeIdle      = "idle"
+	causeCrash     = "crash"
+	causeExit      = "exit"
+)
+
+// deployWindow is how long after a release restarting dynos count as deployed
+const deployWindow = 5 * time.Minute
+
+// pendingCauseTTL drops causes of dynos whose exit we never saw
+const pendingCauseTTL = 10 * time.Minute
+
+var (
+	exitStatusRegexp = regexp.MustCompile(`^Process exited with status (\d+)`)
+	configVarsRegexp = regexp.MustCompile(`^(Set|Remove) (.+?) config vars? by (?:user )?(\S+)`)
+)
+
+// isPlatformLine reports whether line was logged by Heroku itself
+// for a dyno ("heroku web.1") or by the platform API ("app api")
+func isPlatformLine(line string) bool {
+	return strings.Contains(line, " heroku ") || strings.Contains(line, " app api ")
+}
+
+func handlePlatformLine(sink MetricSink, line, userName string) {
+	header, message := parseSyslogLine(line)
+	switch {
+	case header.appName == "heroku" && header.procID != "router":
+		handleDynoStateLine(sink, header, message, userName)
+	case header.procID == "api":
+		handleAPILine(sink, header, message, userName)
+	}
+}
+
+func handleAPILine(sink MetricSink, header syslogHeader, message, userName string) {
+	if strings.HasPrefix(message, "Release v") || strings.HasPrefix(message, "Deploy ") {
+		exits.deployed(userName, header.loggedAt())
+	} else if match := configVarsRegexp.FindStringSubmatch(message); match != nil {
+		handleConfigVarsChange(sink, header, match[1], strings.Split(match[2], ", "), match[3], userName)
+	}
+}
+
+// handleConfigVarsChange sends an event for config vars set or removed by actor.
+// Heroku only logs the names of the vars, their values never reach the drain.
+func handleConfigVarsChange(sink MetricSink, header syslogHeader, action string, names []string, actor, userName string) {
+	verb := "set"
+	if action == "Remove" {
+		verb = "removed"
+	}
+
+	event := statsd.NewEvent(
+		fmt.Sprintf("Config vars %s on %s", verb, userName),
+		fmt.Sprintf("%s %s by %s", strings.Join(names, ", "), verb, actor),
+	)
+	if timestamp, ok := header.parsedTimestamp(); ok {
+		event.Timestamp = timestamp
+	}
+	event.AggregationKey = fmt.Sprintf("heroku-config-vars-%s", userName)
+	event.SourceTypeName = "heroku"
+	event.Tags = []string{"source:api", fmt.Sprintf("app:%v", userName)}
+	recordSend(sink.Event(event))
+}
+
+// handleDynoStateLine tracks why a dyno is stopping and sends heroku.dyno.exit
+// once Heroku logs the exit status of its process
+func handleDynoStateLine(sink MetricSink, header syslogHeader, message, userName string) {
+	now := header.loggedAt()
+	switch {
+	case message == "Cycling":
+		exits.setCause(userName, header.procID, causeCycling, now)
+	case message == "Idling":
+		exits.setCause(userName, header.procID, causeIdle, now)
+	case message == "Restarting":
+		exits.setCause(userName, header.procID, exits.restartCause(userName, now), now)
+	case strings.HasPrefix(message, "State changed from up to down"):
+		exits.setCause(userName, header.procID, causeScaleDown, now)
+	case strings.HasPrefix(message, "Stopping all processes with SIGTERM"):
+		exits.setCause(userName, header.procID, exits.restartCause(userName, now), now)
+	default:
+		if match := exitStatusRegexp.FindStringSubmatch(message); match != nil {
+			status := match[1]
+			cause := exits.takeCause(userName, header.procID, now)
+			if cause == "" {
+				cause = causeCrash
+				if status == "0" {
+					cause = causeExit
+				}
+			}
+
+			tags := []string{
+				fmt.Sprintf("dyno:%s", header.procID),
+				fmt.Sprintf("process_type:%s", header.processType()),
+				fmt.Sprintf("exit_code:%s", status),
+				fmt.Sprintf("cause:%s", cause),
+				fmt.Sprintf("app:%v", userName),
+			}
+			recordSend(sink.Count("heroku.dyno.exit", 1, tags, 1))
+		}
+	}
+}
+
+type pendingCause struct {
+	cause string
+	at    time.Time
+}
+
+// exitTracker remembers per dyno why it is stopping until its process exits
+type exitTracker struct {
+	sync.Mutex
+	causes  map[string]pendingCause
+	deploys map[string]time.Time
+}
+
+var exits = newExitTracker()
+
+func newExitTracker() *exitTracker {
+	return &exitTracker{causes: make(map[string]pendingCause), deploys: make(map[string]time.Time)}
+}
+
+func (t *exitTracker) deployed(app string, at time.Time) {
+	t.Lock()
+	defer t.Unlock()
+	t.deploys[app] = at
+}
+
+func (t *exitTracker) restartCause(app string, now time.Time) string {
+	t.Lock()
+	defer t.Unlock()
+	if since := now.Sub(t.deploys[app]); since >= 0 && since < deployWindow {
+		return causeDeploy
+	}
+	return causeRestart
+}
+
+// setCause records cause for the dyno unless an earlier line already explained the stop
+func (t *exitTracker) setCause(app, dyno, cause string, now time.Time) {
+	t.Lock()
+	defer t.Unlock()
+	key := app + "/" + dyno
+	if pending, ok := t.causes[key]; ok && now.Sub(pending.at) < pendingCauseTTL {
+		return
+	}
+	t.causes[key] = pendingCause{cause, now}
+}
+
+func (t *exitTracker) takeCause(app, dyno string, now time.Time) string {
+	t.Lock()
+	defer t.Unlock()
+	key := app + "/" + dyno
+	pending, ok := t.causes[key]
+	delete(t.causes, key)
+	if !ok || now.Sub(pending.at) >= pendingCauseTTL {
+		return ""
+	}
+	return pending.cause
+}
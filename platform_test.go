@@ -0,0 +1,114 @@
+package statslogdrain
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/stretchr/testify/assert"
+)
+
+const dynoExitBody = `
+83 <45>1 2015-04-02T11:00:00+00:00 host heroku web.1 - Cycling
+83 <45>1 2015-04-02T11:00:01+00:00 host heroku web.1 - State changed from up to starting
+83 <45>1 2015-04-02T11:00:02+00:00 host heroku web.1 - Stopping all processes with SIGTERM
+83 <45>1 2015-04-02T11:00:03+00:00 host heroku web.1 - Process exited with status 143
+83 <45>1 2015-04-02T11:01:00+00:00 host heroku worker.1 - Process exited with status 137
+83 <45>1 2015-04-02T11:01:01+00:00 host heroku worker.1 - State changed from up to crashed
+83 <45>1 2015-04-02T11:02:00+00:00 host heroku web.2 - State changed from up to down
+83 <45>1 2015-04-02T11:02:01+00:00 host heroku web.2 - Stopping all processes with SIGTERM
+83 <45>1 2015-04-02T11:02:02+00:00 host heroku web.2 - Process exited with status 143
+83 <45>1 2015-04-02T11:03:00+00:00 host heroku web.3 - Idling
+83 <45>1 2015-04-02T11:03:01+00:00 host heroku web.3 - State changed from up to down
+83 <45>1 2015-04-02T11:03:02+00:00 host heroku web.3 - Process exited with status 143
+83 <45>1 2015-04-02T11:04:00+00:00 host app api - Release v42 created by user someone@example.com
+83 <45>1 2015-04-02T11:04:01+00:00 host heroku web.1 - Restarting
+83 <45>1 2015-04-02T11:04:02+00:00 host heroku web.1 - Stopping all processes with SIGTERM
+83 <45>1 2015-04-02T11:04:03+00:00 host heroku web.1 - Process exited with status 143
+83 <45>1 2015-04-02T11:05:00+00:00 host heroku run.1234 - Process exited with status 0
+`
+
+func TestDynoExits(t *testing.T) {
+	initServer()
+
+	req, _ := http.NewRequest("POST", "http://example.com/foo", strings.NewReader(strings.TrimSpace(dynoExitBody)))
+	req.SetBasicAuth("test-app", "deadbeef")
+
+	w := httptest.NewRecorder()
+	LogdrainServer(w, req)
+	assert.Equal(t, []command{
+		{"heroku.dyno.exit", 1, []string{"dyno:web.1", "process_type:web", "exit_code:143", "cause:cycling", "app:test-app"}},
+		{"heroku.dyno.exit", 1, []string{"dyno:worker.1", "process_type:worker", "exit_code:137", "cause:crash", "app:test-app"}},
+		{"heroku.dyno.exit", 1, []string{"dyno:web.2", "process_type:web", "exit_code:143", "cause:scale_down", "app:test-app"}},
+		{"heroku.dyno.exit", 1, []string{"dyno:web.3", "process_type:web", "exit_code:143", "cause:idle", "app:test-app"}},
+		{"heroku.dyno.exit", 1, []string{"dyno:web.1", "process_type:web", "exit_code:143", "cause:deploy", "app:test-app"}},
+		{"heroku.dyno.exit", 1, []string{"dyno:run.1234", "process_type:run", "exit_code:0", "cause:exit", "app:test-app"}},
+	}, client.(*stubClient).counts)
+}
+
+func TestDynoRestartWithoutDeploy(t *testing.T) {
+	initServer()
+
+	body := `83 <45>1 2015-04-02T11:04:01+00:00 host heroku web.1 - Restarting
+83 <45>1 2015-04-02T11:04:03+00:00 host heroku web.1 - Process exited with status 143`
+	req, _ := http.NewRequest("POST", "http://example.com/foo", strings.NewReader(body))
+	req.SetBasicAuth("test-app", "deadbeef")
+
+	w := httptest.NewRecorder()
+	LogdrainServer(w, req)
+	assert.Equal(t, []command{
+		{"heroku.dyno.exit", 1, []string{"dyno:web.1", "process_type:web", "exit_code:143", "cause:restart", "app:test-app"}},
+	}, client.(*stubClient).counts)
+}
+
+func TestDynoRestartLongAfterDeploy(t *testing.T) {
+	initServer()
+
+	body := `83 <45>1 2015-04-02T11:04:00+00:00 host app api - Release v42 created by user someone@example.com
+83 <45>1 2015-04-02T11:30:01+00:00 host heroku web.1 - Restarting
+83 <45>1 2015-04-02T11:30:03+00:00 host heroku web.1 - Process exited with status 143`
+	req, _ := http.NewRequest("POST", "http://example.com/foo", strings.NewReader(body))
+	req.SetBasicAuth("test-app", "deadbeef")
+
+	w := httptest.NewRecorder()
+	LogdrainServer(w, req)
+	assert.Equal(t, []command{
+		{"heroku.dyno.exit", 1, []string{"dyno:web.1", "process_type:web", "exit_code:143", "cause:restart", "app:test-app"}},
+	}, client.(*stubClient).counts)
+}
+
+const configVarsBody = `
+120 <45>1 2015-04-02T11:04:00+00:00 host app api - Set DATABASE_URL, REDIS_URL config vars by user someone@example.com
+120 <45>1 2015-04-02T11:05:00+00:00 host app api - Remove DEBUG config vars by someone@example.com
+`
+
+func TestConfigVarsEvents(t *testing.T) {
+	initServer()
+
+	req, _ := http.NewRequest("POST", "http://example.com/foo", strings.NewReader(strings.TrimSpace(configVarsBody)))
+	req.SetBasicAuth("test-app", "deadbeef")
+
+	w := httptest.NewRecorder()
+	LogdrainServer(w, req)
+	assert.Equal(t, []*statsd.Event{
+		{
+			Title:          "Config vars set on test-app",
+			Text:           "DATABASE_URL, REDIS_URL set by someone@example.com",
+			Timestamp:      time.Date(2015, 4, 2, 11, 4, 0, 0, time.UTC),
+			AggregationKey: "heroku-config-vars-test-app",
+			SourceTypeName: "heroku",
+			Tags:           []string{"source:api", "app:test-app"},
+		},
+		{
+			Title:          "Config vars removed on test-app",
+			Text:           "DEBUG removed by someone@example.com",
+			Timestamp:      time.Date(2015, 4, 2, 11, 5, 0, 0, time.UTC),
+			AggregationKey: "heroku-config-vars-test-app",
+			SourceTypeName: "heroku",
+			Tags:           []string{"source:api", "app:test-app"},
+		},
+	}, client.(*stubClient).events)
+}
@@ -0,0 +1,283 @@
+package statslogdrain
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+)
+
+// DefaultPrometheusBuckets cover the router's milliseconds and bytes
+// as well as the dynos' load and megabytes of memory
+var DefaultPrometheusBuckets = []float64{0.1, 0.5, 1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, 100000, 1000000}
+
+// PrometheusConfig configures the Prometheus exporter
+type PrometheusConfig struct {
+	// Buckets are the upper bounds of the histogram buckets, default DefaultPrometheusBuckets
+	Buckets []float64
+	// Expiry is how long a series is exported after its last update, default 5m
+	Expiry time.Duration
+}
+
+// PrometheusSink keeps the metrics as Prometheus counters, gauges and histograms
+// and serves them in the Prometheus text format. Metric names have their dots
+// replaced by underscores, tags become labels and counters get a _total suffix.
+// Sets are exported as gauges of their unique values, service checks as gauges
+// of their status and events are counted as heroku_events_total.
+type PrometheusSink struct {
+	mutex    sync.Mutex
+	config   PrometheusConfig
+	families map[string]*promFamily
+	now      func() time.Time
+}
+
+type promFamily struct {
+	kind   string
+	series map[string]*promSeries
+}
+
+type promSeries struct {
+	labels  [][2]string
+	value   float64
+	buckets []uint64
+	sum     float64
+	count   uint64
+	set     map[string]bool
+	updated time.Time
+}
+
+// NewPrometheusSink returns an empty exporter for config
+func NewPrometheusSink(config PrometheusConfig) *PrometheusSink {
+	if len(config.Buckets) == 0 {
+		config.Buckets = DefaultPrometheusBuckets
+	}
+	if config.Expiry <= 0 {
+		config.Expiry = 5 * time.Minute
+	}
+	return &PrometheusSink{config: config, families: make(map[string]*promFamily), now: time.Now}
+}
+
+var (
+	invalidPromNameRegexp  = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+	invalidPromLabelRegexp = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+)
+
+func promName(name string) string {
+	name = invalidPromNameRegexp.ReplaceAllString(name, "_")
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// promLabels turns key:value tags into labels sorted by name, the last value of a name wins
+func promLabels(tags []string) [][2]string {
+	byName := make(map[string]string)
+	for _, tag := range tags {
+		keyValue := strings.SplitN(tag, ":", 2)
+		name := promName(invalidPromLabelRegexp.ReplaceAllString(keyValue[0], "_"))
+		if name == "" || strings.HasPrefix(name, "__") {
+			continue
+		}
+		value := ""
+		if len(keyValue) == 2 {
+			value = keyValue[1]
+		}
+		byName[name] = value
+	}
+
+	labels := make([][2]string, 0, len(byName))
+	for name, value := range byName {
+		labels = append(labels, [2]string{name, value})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i][0] < labels[j][0] })
+	return labels
+}
+
+// series returns the series of name and tags, or an error if name is already of another kind
+func (s *PrometheusSink) series(name, kind string, tags []string) (*promSeries, error) {
+	name = promName(name)
+	family, ok := s.families[name]
+	if !ok {
+		family = &promFamily{kind: kind, series: make(map[string]*promSeries)}
+		s.families[name] = family
+	} else if family.kind != kind {
+		return nil, fmt.Errorf("prometheus metric %s is a %s, not a %s", name, family.kind, kind)
+	}
+
+	labels := promLabels(tags)
+	key := formatLabels(labels, "")
+	series, ok := family.series[key]
+	if !ok {
+		series = &promSeries{labels: labels}
+		if kind == "histogram" {
+			series.buckets = make([]uint64, len(s.config.Buckets))
+		}
+		family.series[key] = series
+	}
+	series.updated = s.now()
+	return series, nil
+}
+
+func (s *PrometheusSink) Gauge(name string, value float64, tags []string, rate float64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	series, err := s.series(name, "gauge", tags)
+	if err != nil {
+		return err
+	}
+	series.value = value
+	return nil
+}
+
+func (s *PrometheusSink) Count(name string, value int64, tags []string, rate float64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	series, err := s.series(name+"_total", "counter", tags)
+	if err != nil {
+		return err
+	}
+	series.value += float64(value)
+	return nil
+}
+
+func (s *PrometheusSink) Histogram(name string, value float64, tags []string, rate float64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	series, err := s.series(name, "histogram", tags)
+	if err != nil {
+		return err
+	}
+	for i, bound := range s.config.Buckets {
+		if value <= bound {
+			series.buckets[i]++
+		}
+	}
+	series.sum += value
+	series.count++
+	return nil
+}
+
+func (s *PrometheusSink) Set(name string, value string, tags []string, rate float64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	series, err := s.series(name, "gauge", tags)
+	if err != nil {
+		return err
+	}
+	if series.set == nil {
+		series.set = make(map[string]bool)
+	}
+	series.set[value] = true
+	series.value = float64(len(series.set))
+	return nil
+}
+
+func (s *PrometheusSink) TimeInMilliseconds(name string, value float64, tags []string, rate float64) error {
+	return s.Histogram(name, value, tags, rate)
+}
+
+func (s *PrometheusSink) Event(e *statsd.Event) error {
+	return s.Count("heroku.events", 1, e.Tags, 1)
+}
+
+func (s *PrometheusSink) ServiceCheck(sc *statsd.ServiceCheck) error {
+	return s.Gauge(sc.Name+".status", float64(sc.Status), sc.Tags, 1)
+}
+
+// expire removes the series not updated within the expiry. The caller must hold the lock.
+func (s *PrometheusSink) expire() {
+	cutoff := s.now().Add(-s.config.Expiry)
+	for name, family := range s.families {
+		for key, series := range family.series {
+			if series.updated.Before(cutoff) {
+				delete(family.series, key)
+			}
+		}
+		if len(family.series) == 0 {
+			delete(s.families, name)
+		}
+	}
+}
+
+// ServeHTTP serves the current metrics in the Prometheus text format
+func (s *PrometheusSink) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(s.exposition())
+}
+
+// exposition formats the current metrics in the Prometheus text format. It
+// formats into memory, so that a slow scraper does not block the drain.
+func (s *PrometheusSink) exposition() []byte {
+	var out bytes.Buffer
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.expire()
+
+	names := make([]string, 0, len(s.families))
+	for name := range s.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		family := s.families[name]
+		fmt.Fprintf(&out, "# TYPE %s %s\n", name, family.kind)
+
+		keys := make([]string, 0, len(family.series))
+		for key := range family.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			series := family.series[key]
+			if family.kind != "histogram" {
+				fmt.Fprintf(&out, "%s%s %s\n", name, key, formatPromValue(series.value))
+				continue
+			}
+			for i, bound := range s.config.Buckets {
+				fmt.Fprintf(&out, "%s_bucket%s %d\n", name, formatLabels(series.labels, formatPromValue(bound)), series.buckets[i])
+			}
+			fmt.Fprintf(&out, "%s_bucket%s %d\n", name, formatLabels(series.labels, "+Inf"), series.count)
+			fmt.Fprintf(&out, "%s_sum%s %s\n", name, key, formatPromValue(series.sum))
+			fmt.Fprintf(&out, "%s_count%s %d\n", name, key, series.count)
+		}
+	}
+	return out.Bytes()
+}
+
+var promLabelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels formats labels as {name="value",..}, adding the le label unless empty
+func formatLabels(labels [][2]string, le string) string {
+	if len(labels) == 0 && le == "" {
+		return ""
+	}
+	parts := make([]string, 0, len(labels)+1)
+	for _, label := range labels {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, label[0], promLabelValueReplacer.Replace(label[1])))
+	}
+	if le != "" {
+		parts = append(parts, fmt.Sprintf(`le="%s"`, le))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatPromValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
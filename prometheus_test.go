@@ -0,0 +1,110 @@
+package statslogdrain
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func scrape(sink *PrometheusSink) string {
+	w := httptest.NewRecorder()
+	sink.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	return w.Body.String()
+}
+
+func TestPrometheusSinkRouterMetrics(t *testing.T) {
+	initServer()
+	sink := NewPrometheusSink(PrometheusConfig{Buckets: []float64{10, 100}})
+	SetMetricSink(sink)
+
+	req, _ := http.NewRequest("POST", "http://example.com/foo", strings.NewReader(strings.TrimSpace(routerMetricsBody)))
+	req.SetBasicAuth("test-app", "deadbeef")
+	LogdrainServer(httptest.NewRecorder(), req)
+
+	metrics := scrape(sink)
+	assert.Contains(t, metrics, "# TYPE heroku_router_request_service histogram\n")
+	assert.Contains(t, metrics, `heroku_router_request_service_bucket{app="test-app",dyno="web.1",host="myapp.com",method="POST",status="201",statusgroup="2xx",le="100"} 1`+"\n")
+	assert.Contains(t, metrics, `heroku_router_request_service_bucket{app="test-app",dyno="web.2",host="myapp.com",method="GET",status="200",statusgroup="2xx",le="10"} 0`+"\n")
+	assert.Contains(t, metrics, `heroku_router_request_service_bucket{app="test-app",dyno="web.2",host="myapp.com",method="GET",status="200",statusgroup="2xx",le="+Inf"} 1`+"\n")
+	assert.Contains(t, metrics, `heroku_router_request_service_sum{app="test-app",code="H12",dyno="web.1",host="myapp.com",method="GET",status="503",statusgroup="5xx"} 30001`+"\n")
+	assert.Contains(t, metrics, `heroku_router_request_service_count{app="test-app",code="H12",dyno="web.1",host="myapp.com",method="GET",status="503",statusgroup="5xx"} 1`+"\n")
+}
+
+func TestPrometheusSinkTypes(t *testing.T) {
+	sink := NewPrometheusSink(PrometheusConfig{})
+	tags := []string{"app:test-app", `source:"quoted"`}
+	sink.Count("heroku.dyno.exit", 1, tags, 1)
+	sink.Count("heroku.dyno.exit", 2, tags, 1)
+	sink.Gauge("heroku.dyno.load-avg-1m", 0.5, tags, 1)
+	sink.Set("heroku.custom.users", "alice", tags, 1)
+	sink.Set("heroku.custom.users", "bob", tags, 1)
+	assert.EqualError(t, sink.Histogram("heroku.custom.users", 1, tags, 1), "prometheus metric heroku_custom_users is a gauge, not a histogram")
+
+	assert.Equal(t, `# TYPE heroku_custom_users gauge
+heroku_custom_users{app="test-app",source="\"quoted\""} 2
+# TYPE heroku_dyno_exit_total counter
+heroku_dyno_exit_total{app="test-app",source="\"quoted\""} 3
+# TYPE heroku_dyno_load_avg_1m gauge
+heroku_dyno_load_avg_1m{app="test-app",source="\"quoted\""} 0.5
+`, scrape(sink))
+}
+
+func TestPrometheusSinkExpiry(t *testing.T) {
+	now := time.Date(2015, 4, 2, 11, 0, 0, 0, time.UTC)
+	sink := NewPrometheusSink(PrometheusConfig{Expiry: time.Minute})
+	sink.now = func() time.Time { return now }
+
+	sink.Gauge("heroku.dyno.memory_total", 100, []string{"dyno:web.1"}, 1)
+	now = now.Add(45 * time.Second)
+	sink.Gauge("heroku.dyno.memory_total", 200, []string{"dyno:web.2"}, 1)
+	now = now.Add(30 * time.Second)
+
+	assert.Equal(t, "# TYPE heroku_dyno_memory_total gauge\nheroku_dyno_memory_total{dyno=\"web.2\"} 200\n", scrape(sink))
+	now = now.Add(time.Minute)
+	assert.Equal(t, "", scrape(sink))
+}
+
+// stalledWriter is a scraper that stops reading the response
+type stalledWriter struct {
+	*httptest.ResponseRecorder
+	unblock chan struct{}
+}
+
+func (w *stalledWriter) Write(b []byte) (int, error) {
+	<-w.unblock
+	return w.ResponseRecorder.Write(b)
+}
+
+func TestPrometheusSinkSlowScraper(t *testing.T) {
+	sink := NewPrometheusSink(PrometheusConfig{})
+	for i := 0; i < 200; i++ {
+		sink.Count("heroku.logs.errors", 1, []string{fmt.Sprintf("dyno:web.%d", i), "app:test-app"}, 1)
+	}
+
+	w := &stalledWriter{ResponseRecorder: httptest.NewRecorder(), unblock: make(chan struct{})}
+	served := make(chan struct{})
+	go func() {
+		sink.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		close(served)
+	}()
+
+	counted := make(chan struct{})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		sink.Count("heroku.logs.errors", 1, []string{"app:test-app"}, 1)
+		close(counted)
+	}()
+	select {
+	case <-counted:
+	case <-time.After(time.Second):
+		t.Error("Count blocked by a stalled scraper")
+	}
+	close(w.unblock)
+	<-served
+	<-counted
+}
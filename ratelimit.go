@@ -0,0 +1,259 @@
+package statslogdrain
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+)
+
+var errRateLimited = errors.New("metrics rate limit exceeded")
+
+// tokenBucket allows rate events per second with bursts of up to one second's worth
+type tokenBucket struct {
+	sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	return &tokenBucket{rate: rate, tokens: rate}
+}
+
+// refill adds the tokens accrued since the last take, up to one second's worth.
+// The caller must hold the lock.
+func (b *tokenBucket) refill(now time.Time) {
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+	}
+	if burst := b.rate; b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+}
+
+// take removes n tokens from the bucket if it holds that many
+func (b *tokenBucket) take(n float64, now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	b.Lock()
+	defer b.Unlock()
+	b.refill(now)
+
+	if b.tokens < n {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+// takeBatch removes n tokens for a whole batch if the bucket holds that many
+// or is full, borrowing from the next seconds, so that a batch larger than
+// one second's worth is still admitted when the app was idle
+func (b *tokenBucket) takeBatch(n float64, now time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+	b.refill(now)
+
+	if b.tokens < n && b.tokens < b.rate {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+// takeUpTo removes up to n whole tokens, returning how many it took
+func (b *tokenBucket) takeUpTo(n int, now time.Time) int {
+	b.Lock()
+	defer b.Unlock()
+	b.refill(now)
+
+	taken := n
+	if available := int(b.tokens); available < n {
+		taken = available
+	}
+	if taken < 0 {
+		taken = 0
+	}
+	b.tokens -= float64(taken)
+	return taken
+}
+
+// appLimiter holds the token buckets of an app with ingestion limits
+type appLimiter struct {
+	lines   *tokenBucket
+	metrics *tokenBucket
+	reject  bool
+}
+
+func newAppLimiter(config AppConfig) *appLimiter {
+	if config.LinesPerSecond <= 0 && config.MetricsPerSecond <= 0 {
+		return nil
+	}
+
+	limiter := &appLimiter{reject: config.RejectOverLimit}
+	if config.LinesPerSecond > 0 {
+		limiter.lines = newTokenBucket(config.LinesPerSecond)
+	}
+	if config.MetricsPerSecond > 0 {
+		limiter.metrics = newTokenBucket(config.MetricsPerSecond)
+	}
+	return limiter
+}
+
+// admitLines decides which of the lines of a request are processed before
+// any of them is. In reject mode all are admitted or none, in sample mode
+// lines are sampled evenly at the rate returned, by which counts are scaled
+// back up.
+func (l *appLimiter) admitLines(lines int, now time.Time) (admitted func(i int) bool, rate float64) {
+	all := func(i int) bool { return true }
+	if l.lines == nil || lines == 0 {
+		return all, 1
+	}
+	if l.reject {
+		if l.lines.takeBatch(float64(lines), now) {
+			return all, 1
+		}
+		return func(i int) bool { return false }, 1
+	}
+
+	taken := l.lines.takeUpTo(lines, now)
+	if taken == lines {
+		return all, 1
+	}
+	return func(i int) bool { return (i+1)*taken/lines > i*taken/lines }, float64(taken) / float64(lines)
+}
+
+// limitedClient drops metrics over the app's metrics limit and counts what passed.
+// Counts of sampled lines are scaled up by the sample rate of the lines before
+// they reach the sink, so that sinks need not sample again or support rates.
+type limitedClient struct {
+	MetricSink
+	bucket     *tokenBucket
+	usage      *sinkUsage
+	sampleRate float64
+}
+
+// sinkUsage counts the metrics of a request, shared by the
+// timestamped copies of its limitedClient
+type sinkUsage struct {
+	sent    int64
+	dropped int64
+}
+
+func (c *limitedClient) allow() bool {
+	if !c.bucket.take(1, time.Now()) {
+		c.usage.dropped++
+		return false
+	}
+	c.usage.sent++
+	return true
+}
+
+// WithTimestamp implements TimestampedSink, passing timestamp on to the limited sink
+func (c *limitedClient) WithTimestamp(timestamp time.Time) MetricSink {
+	timestamped, ok := c.MetricSink.(TimestampedSink)
+	if !ok {
+		return c
+	}
+	return &limitedClient{MetricSink: timestamped.WithTimestamp(timestamp), bucket: c.bucket, usage: c.usage, sampleRate: c.sampleRate}
+}
+
+// scaled returns the count value of the lines the sampled lines stand for
+func (c *limitedClient) scaled(value int64) int64 {
+	if c.sampleRate <= 0 || c.sampleRate >= 1 {
+		return value
+	}
+	return int64(math.Round(float64(value) / c.sampleRate))
+}
+
+func (c *limitedClient) Histogram(name string, value float64, tags []string, rate float64) error {
+	if !c.allow() {
+		return errRateLimited
+	}
+	return c.MetricSink.Histogram(name, value, tags, rate)
+}
+
+func (c *limitedClient) Count(name string, value int64, tags []string, rate float64) error {
+	if !c.allow() {
+		return errRateLimited
+	}
+	return c.MetricSink.Count(name, c.scaled(value), tags, rate)
+}
+
+func (c *limitedClient) Gauge(name string, value float64, tags []string, rate float64) error {
+	if !c.allow() {
+		return errRateLimited
+	}
+	return c.MetricSink.Gauge(name, value, tags, rate)
+}
+
+func (c *limitedClient) Set(name string, value string, tags []string, rate float64) error {
+	if !c.allow() {
+		return errRateLimited
+	}
+	return c.MetricSink.Set(name, value, tags, rate)
+}
+
+func (c *limitedClient) TimeInMilliseconds(name string, value float64, tags []string, rate float64) error {
+	if !c.allow() {
+		return errRateLimited
+	}
+	return c.MetricSink.TimeInMilliseconds(name, value, tags, rate)
+}
+
+func (c *limitedClient) Event(e *statsd.Event) error {
+	if !c.allow() {
+		return errRateLimited
+	}
+	return c.MetricSink.Event(e)
+}
+
+func (c *limitedClient) ServiceCheck(sc *statsd.ServiceCheck) error {
+	if !c.allow() {
+		return errRateLimited
+	}
+	return c.MetricSink.ServiceCheck(sc)
+}
+
+// reportUsage sends the lines and metrics an app sent and how many of them were dropped
+func reportUsage(userName string, lines, droppedLines int, sink *limitedClient) {
+	tags := []string{fmt.Sprintf("app:%v", userName)}
+	recordSend(client.Count("heroku.logdrain.lines", int64(lines), tags, 1))
+	recordSend(client.Count("heroku.logdrain.metrics", sink.usage.sent, tags, 1))
+	if droppedLines > 0 {
+		recordSend(client.Count("heroku.logdrain.dropped", int64(droppedLines), append(tags, "type:lines"), 1))
+	}
+	if sink.usage.dropped > 0 {
+		recordSend(client.Count("heroku.logdrain.dropped", sink.usage.dropped, append(tags, "type:metrics"), 1))
+	}
+}
+
+var (
+	appLimiters      = map[string]*appLimiter{}
+	appLimitersMutex sync.RWMutex
+)
+
+func appLimiterFor(userName string) *appLimiter {
+	appLimitersMutex.RLock()
+	defer appLimitersMutex.RUnlock()
+	return appLimiters[userName]
+}
+
+func setAppLimiters(configs map[string]AppConfig) {
+	limiters := make(map[string]*appLimiter)
+	for app, config := range configs {
+		if limiter := newAppLimiter(config); limiter != nil {
+			limiters[app] = limiter
+		}
+	}
+
+	appLimitersMutex.Lock()
+	defer appLimitersMutex.Unlock()
+	appLimiters = limiters
+}
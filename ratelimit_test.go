@@ -0,0 +1,152 @@
+package statslogdrain
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Date(2015, 4, 2, 11, 0, 0, 0, time.UTC)
+	bucket := newTokenBucket(2)
+
+	assert.True(t, bucket.take(1, now))
+	assert.True(t, bucket.take(1, now))
+	assert.False(t, bucket.take(1, now))
+	assert.True(t, bucket.take(1, now.Add(500*time.Millisecond)))
+	assert.False(t, bucket.take(3, now.Add(time.Hour)))
+	assert.True(t, bucket.take(2, now.Add(time.Hour)))
+
+	var unlimited *tokenBucket
+	assert.True(t, unlimited.take(1000, now))
+}
+
+func TestTokenBucketBatches(t *testing.T) {
+	now := time.Date(2015, 4, 2, 11, 0, 0, 0, time.UTC)
+	bucket := newTokenBucket(2)
+
+	assert.True(t, bucket.takeBatch(5, now))
+	assert.False(t, bucket.takeBatch(1, now.Add(time.Second)))
+	assert.True(t, bucket.takeBatch(1, now.Add(2*time.Second)))
+
+	bucket = newTokenBucket(2)
+	assert.Equal(t, 2, bucket.takeUpTo(3, now))
+	assert.Equal(t, 0, bucket.takeUpTo(3, now))
+	assert.Equal(t, 1, bucket.takeUpTo(3, now.Add(500*time.Millisecond)))
+}
+
+func TestRateLimitSample(t *testing.T) {
+	initServer()
+	SetAppConfigs(map[string]AppConfig{"test-app": {LinesPerSecond: 2, MetricsPerSecond: 4}})
+
+	req, _ := http.NewRequest("POST", "http://example.com/foo", strings.NewReader(strings.TrimSpace(routerMetricsBody)))
+	req.SetBasicAuth("test-app", "deadbeef")
+
+	w := httptest.NewRecorder()
+	LogdrainServer(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Len(t, client.(*stubClient).histograms, 4)
+	assert.Equal(t, []command{
+		{"heroku.logdrain.lines", 3, []string{"app:test-app"}},
+		{"heroku.logdrain.metrics", 4, []string{"app:test-app"}},
+		{"heroku.logdrain.dropped", 1, []string{"app:test-app", "type:lines"}},
+		{"heroku.logdrain.dropped", 2, []string{"app:test-app", "type:metrics"}},
+	}, client.(*stubClient).counts)
+}
+
+func TestRateLimitSampleThroughStatsd(t *testing.T) {
+	initServer()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+	statsdClient, err := NewStatsdClient(StatsdConfig{Address: conn.LocalAddr().String()})
+	assert.NoError(t, err)
+	SetMetricSink(statsdClient)
+	requests, _ := ParseCounterRule("requests", "at=info")
+	SetAppConfigs(map[string]AppConfig{"test-app": {LinesPerSecond: 100, CounterRules: []CounterRule{requests}}})
+
+	line := `<158>1 2015-04-02T11:52:34.520012+00:00 host heroku router - at=info method=POST path="/users" host=myapp.com dyno=web.1 connect=1ms service=37ms status=201 bytes=828`
+	req, _ := http.NewRequest("POST", "http://example.com/foo", strings.NewReader(strings.Repeat(line+"\n", 1000)))
+	req.SetBasicAuth("test-app", "deadbeef")
+	LogdrainServer(httptest.NewRecorder(), req)
+	assert.NoError(t, statsdClient.Close())
+
+	services, counted := 0, int64(0)
+	buf := make([]byte, 65536)
+	for {
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		for _, metric := range strings.Split(strings.TrimSpace(string(buf[:n])), "\n") {
+			assert.NotContains(t, metric, "|@", "sampled lines are not sampled again")
+			if strings.HasPrefix(metric, "heroku.router.request.service:") {
+				services++
+			}
+			if strings.HasPrefix(metric, "heroku.logs.requests:") {
+				value, _ := strconv.ParseInt(strings.SplitN(strings.TrimPrefix(metric, "heroku.logs.requests:"), "|", 2)[0], 10, 64)
+				counted += value
+			}
+		}
+	}
+	assert.Equal(t, 100, services)
+	assert.Equal(t, int64(1000), counted)
+}
+
+func TestRateLimitReject(t *testing.T) {
+	initServer()
+	SetAppConfigs(map[string]AppConfig{"test-app": {LinesPerSecond: 5, RejectOverLimit: true}})
+
+	codes := []int{}
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("POST", "http://example.com/foo", strings.NewReader(strings.TrimSpace(routerMetricsBody)))
+		req.SetBasicAuth("test-app", "deadbeef")
+
+		w := httptest.NewRecorder()
+		LogdrainServer(w, req)
+		codes = append(codes, w.Code)
+	}
+	assert.Equal(t, []int{200, 429}, codes)
+	assert.Len(t, client.(*stubClient).histograms, 9)
+	assert.Contains(t, client.(*stubClient).counts, command{"heroku.logdrain.dropped", 3, []string{"app:test-app", "type:lines"}})
+}
+
+func TestRateLimitRejectLargeBatch(t *testing.T) {
+	initServer()
+	SetAppConfigs(map[string]AppConfig{"test-app": {LinesPerSecond: 1, RejectOverLimit: true}})
+
+	codes := []int{}
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("POST", "http://example.com/foo", strings.NewReader(strings.TrimSpace(routerMetricsBody)))
+		req.SetBasicAuth("test-app", "deadbeef")
+
+		w := httptest.NewRecorder()
+		LogdrainServer(w, req)
+		codes = append(codes, w.Code)
+	}
+	assert.Equal(t, []int{200, 429}, codes)
+	assert.Len(t, client.(*stubClient).histograms, 9)
+}
+
+func TestLimitedClient(t *testing.T) {
+	stub := &stubClient{}
+	sink := &limitedClient{MetricSink: stub, bucket: newTokenBucket(2), usage: &sinkUsage{}}
+
+	assert.NoError(t, sink.Gauge("heroku.custom.queue.depth", 12, nil, 1))
+	assert.NoError(t, sink.Set("heroku.custom.users", "alice", nil, 1))
+	assert.Equal(t, errRateLimited, sink.ServiceCheck(&statsd.ServiceCheck{Name: "heroku.app.up", Status: statsd.Ok}))
+
+	assert.Equal(t, []command{{"heroku.custom.queue.depth", 12, nil}}, stub.gauges)
+	assert.Equal(t, []string{"heroku.custom.users:alice"}, stub.sets)
+	assert.Empty(t, stub.serviceChecks)
+	assert.Equal(t, int64(2), sink.usage.sent)
+	assert.Equal(t, int64(1), sink.usage.dropped)
+}
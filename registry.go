@@ -0,0 +1,223 @@
+package statslogdrain
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Errors returned by Registry
+var (
+	ErrAppExists   = errors.New("app already registered")
+	ErrAppNotFound = errors.New("app not registered")
+	ErrAppStatic   = errors.New("app is configured statically and cannot be changed")
+)
+
+// Registry holds the apps allowed to send to the drain and their credentials.
+// It is safe for concurrent use, can be changed while the drain is serving
+// requests and persists itself to a JSON file if created with a path.
+type Registry struct {
+	mutex  sync.RWMutex
+	path   string
+	static map[string][]Credential
+	apps   map[string][]Credential
+}
+
+// NewRegistry returns a registry persisted to path, loading the apps already stored there.
+// With an empty path the registry is kept in memory only. The static apps, e.g.
+// configured by environment, are neither persisted nor can they be changed.
+func NewRegistry(path string, static map[string][]Credential) (*Registry, error) {
+	r := &Registry{path: path, static: static, apps: make(map[string][]Credential)}
+	if path == "" {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return r, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &r.apps); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Authenticate checks the basic auth password of req against the app's credentials
+func (r *Registry) Authenticate(req *http.Request) (string, bool) {
+	userName, password, ok := req.BasicAuth()
+	if !ok {
+		return userName, false
+	}
+
+	credentials, known := r.credentials(userName)
+	if !known {
+		return userName, false
+	}
+	return userName, checkCredentials(userName, password, credentials)
+}
+
+func (r *Registry) knows(userName string) bool {
+	_, known := r.credentials(userName)
+	return known
+}
+
+func (r *Registry) credentials(app string) ([]Credential, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if credentials, known := r.static[app]; known {
+		return credentials, true
+	}
+	credentials, known := r.apps[app]
+	return credentials, known
+}
+
+// Apps returns the names of all registered apps in alphabetical order
+func (r *Registry) Apps() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	apps := make([]string, 0, len(r.static)+len(r.apps))
+	for app := range r.static {
+		apps = append(apps, app)
+	}
+	for app := range r.apps {
+		if _, static := r.static[app]; !static {
+			apps = append(apps, app)
+		}
+	}
+	sort.Strings(apps)
+	return apps
+}
+
+// CredentialNames returns the names of the app's credentials, never their secrets
+func (r *Registry) CredentialNames(app string) ([]string, error) {
+	credentials, known := r.credentials(app)
+	if !known {
+		return nil, ErrAppNotFound
+	}
+	names := []string{}
+	for _, credential := range credentials {
+		names = append(names, credential.Name)
+	}
+	return names, nil
+}
+
+// Add registers a new app with a single credential
+func (r *Registry) Add(app string, credential Credential) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, static := r.static[app]; static {
+		return ErrAppExists
+	}
+	if _, known := r.apps[app]; known {
+		return ErrAppExists
+	}
+	credential.Name = "current"
+	return r.update(app, []Credential{credential})
+}
+
+// Remove unregisters app
+func (r *Registry) Remove(app string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, static := r.static[app]; static {
+		return ErrAppStatic
+	}
+	if _, known := r.apps[app]; !known {
+		return ErrAppNotFound
+	}
+	return r.update(app, nil)
+}
+
+// Rotate makes credential the app's current credential. The previously
+// current one is kept as "previous" until the next rotation, so that
+// the drain URL can be switched without rejecting requests.
+func (r *Registry) Rotate(app string, credential Credential) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, static := r.static[app]; static {
+		return ErrAppStatic
+	}
+	credentials, known := r.apps[app]
+	if !known {
+		return ErrAppNotFound
+	}
+
+	credential.Name = "current"
+	rotated := []Credential{credential}
+	for _, c := range credentials {
+		if c.Name == "current" {
+			c.Name = "previous"
+			rotated = append(rotated, c)
+		}
+	}
+	return r.update(app, rotated)
+}
+
+// update sets the app's credentials, removing it for nil, and saves the registry.
+// The caller must hold the write lock.
+func (r *Registry) update(app string, credentials []Credential) error {
+	previous, known := r.apps[app]
+	if credentials == nil {
+		delete(r.apps, app)
+	} else {
+		r.apps[app] = credentials
+	}
+
+	if err := r.save(); err != nil {
+		if known {
+			r.apps[app] = previous
+		} else {
+			delete(r.apps, app)
+		}
+		return err
+	}
+	return nil
+}
+
+// save atomically writes the registry to its file
+func (r *Registry) save() error {
+	if r.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(r.apps, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(r.path), filepath.Base(r.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), r.path)
+}
+
+// GeneratePassword returns a random password for a drain URL
+func GeneratePassword() (string, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
@@ -0,0 +1,65 @@
+package statslogdrain
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func authenticateWith(a Authenticator, userName, password string) bool {
+	req, _ := http.NewRequest("POST", "http://example.com/foo", nil)
+	req.SetBasicAuth(userName, password)
+	_, ok := a.Authenticate(req)
+	return ok
+}
+
+func TestRegistry(t *testing.T) {
+	initServer()
+	registry, err := NewRegistry("", map[string][]Credential{"static-app": {{Name: "current", Password: "f00"}}})
+	assert.NoError(t, err)
+
+	assert.NoError(t, registry.Add("test-app", Credential{Password: "deadbeef"}))
+	assert.Equal(t, ErrAppExists, registry.Add("test-app", Credential{Password: "deadbeef"}))
+	assert.Equal(t, ErrAppExists, registry.Add("static-app", Credential{Password: "deadbeef"}))
+	assert.Equal(t, []string{"static-app", "test-app"}, registry.Apps())
+	assert.True(t, authenticateWith(registry, "test-app", "deadbeef"))
+	assert.True(t, authenticateWith(registry, "static-app", "f00"))
+
+	assert.NoError(t, registry.Rotate("test-app", Credential{Password: "cafebabe"}))
+	assert.NoError(t, registry.Rotate("test-app", Credential{Password: "c0ffee"}))
+	names, _ := registry.CredentialNames("test-app")
+	assert.Equal(t, []string{"current", "previous"}, names)
+	assert.False(t, authenticateWith(registry, "test-app", "deadbeef"))
+	assert.True(t, authenticateWith(registry, "test-app", "cafebabe"))
+	assert.True(t, authenticateWith(registry, "test-app", "c0ffee"))
+
+	assert.Equal(t, ErrAppStatic, registry.Rotate("static-app", Credential{Password: "deadbeef"}))
+	assert.Equal(t, ErrAppStatic, registry.Remove("static-app"))
+	assert.Equal(t, ErrAppNotFound, registry.Rotate("unknown-app", Credential{Password: "deadbeef"}))
+	assert.NoError(t, registry.Remove("test-app"))
+	assert.Equal(t, ErrAppNotFound, registry.Remove("test-app"))
+	assert.False(t, authenticateWith(registry, "test-app", "c0ffee"))
+}
+
+func TestRegistryPersistence(t *testing.T) {
+	initServer()
+	path := filepath.Join(t.TempDir(), "registry.json")
+	registry, err := NewRegistry(path, map[string][]Credential{"static-app": {{Name: "current", Password: "f00"}}})
+	assert.NoError(t, err)
+	assert.NoError(t, registry.Add("test-app", Credential{Password: "deadbeef"}))
+
+	data, _ := os.ReadFile(path)
+	assert.NotContains(t, string(data), "static-app")
+
+	reloaded, err := NewRegistry(path, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test-app"}, reloaded.Apps())
+	assert.True(t, authenticateWith(reloaded, "test-app", "deadbeef"))
+
+	os.WriteFile(path, []byte("{"), 0600)
+	_, err = NewRegistry(path, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,128 @@
+package statslogdrain
+
+import (
+	"math"
+	"sort"
+)
+
+// sketchRelativeAccuracy bounds the relative error of the quantiles of a sketch
+const sketchRelativeAccuracy = 0.01
+
+// sketchMinValue is the smallest magnitude kept apart from zero
+const sketchMinValue = 1e-9
+
+var (
+	sketchGamma    = (1 + sketchRelativeAccuracy) / (1 - sketchRelativeAccuracy)
+	sketchLogGamma = math.Log(sketchGamma)
+)
+
+// sketch is a mergeable quantile sketch after DDSketch: values are counted
+// in logarithmically sized buckets, so every quantile it returns is within
+// sketchRelativeAccuracy of the exact one, in memory growing with the range
+// of values rather than their number.
+type sketch struct {
+	positive map[int]uint64
+	negative map[int]uint64
+	zeros    uint64
+	count    uint64
+	sum      float64
+	min, max float64
+}
+
+func newSketch() *sketch {
+	return &sketch{positive: make(map[int]uint64), negative: make(map[int]uint64)}
+}
+
+func sketchIndex(value float64) int {
+	return int(math.Ceil(math.Log(value) / sketchLogGamma))
+}
+
+// sketchValue returns the value a bucket stands for, with the least relative error to its bounds
+func sketchValue(index int) float64 {
+	return 2 * math.Pow(sketchGamma, float64(index)) / (1 + sketchGamma)
+}
+
+func (s *sketch) add(value float64) {
+	switch {
+	case value > sketchMinValue:
+		s.positive[sketchIndex(value)]++
+	case value < -sketchMinValue:
+		s.negative[sketchIndex(-value)]++
+	default:
+		s.zeros++
+	}
+	if s.count == 0 || value < s.min {
+		s.min = value
+	}
+	if s.count == 0 || value > s.max {
+		s.max = value
+	}
+	s.count++
+	s.sum += value
+}
+
+// merge adds the values counted by other
+func (s *sketch) merge(other *sketch) {
+	if other.count == 0 {
+		return
+	}
+	for index, count := range other.positive {
+		s.positive[index] += count
+	}
+	for index, count := range other.negative {
+		s.negative[index] += count
+	}
+	if s.count == 0 || other.min < s.min {
+		s.min = other.min
+	}
+	if s.count == 0 || other.max > s.max {
+		s.max = other.max
+	}
+	s.zeros += other.zeros
+	s.count += other.count
+	s.sum += other.sum
+}
+
+// quantile returns the value at quantile q between 0 and 1
+func (s *sketch) quantile(q float64) float64 {
+	if s.count == 0 {
+		return math.NaN()
+	}
+	if q <= 0 {
+		return s.min
+	}
+	if q >= 1 {
+		return s.max
+	}
+
+	rank := uint64(q * float64(s.count-1))
+	var seen uint64
+
+	negative := sortedIndexes(s.negative)
+	for i := len(negative) - 1; i >= 0; i-- {
+		seen += s.negative[negative[i]]
+		if seen > rank {
+			return -sketchValue(negative[i])
+		}
+	}
+	seen += s.zeros
+	if seen > rank {
+		return 0
+	}
+	for _, index := range sortedIndexes(s.positive) {
+		seen += s.positive[index]
+		if seen > rank {
+			return sketchValue(index)
+		}
+	}
+	return s.max
+}
+
+func sortedIndexes(buckets map[int]uint64) []int {
+	indexes := make([]int, 0, len(buckets))
+	for index := range buckets {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
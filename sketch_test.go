@@ -0,0 +1,62 @@
+package statslogdrain
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSketchQuantiles(t *testing.T) {
+	s := newSketch()
+	values := make([]float64, 10000)
+	random := rand.New(rand.NewSource(1))
+	for i := range values {
+		values[i] = math.Exp(random.NormFloat64()*2) * 100
+		s.add(values[i])
+	}
+	sort.Float64s(values)
+
+	for _, q := range []float64{0.5, 0.95, 0.99} {
+		exact := values[int(q*float64(len(values)-1))]
+		assert.InEpsilon(t, exact, s.quantile(q), sketchRelativeAccuracy, "quantile %v", q)
+	}
+	assert.Equal(t, values[0], s.quantile(0))
+	assert.Equal(t, values[len(values)-1], s.quantile(1))
+	assert.Equal(t, uint64(10000), s.count)
+}
+
+func TestSketchNegativeAndZero(t *testing.T) {
+	s := newSketch()
+	for _, value := range []float64{-100, -10, 0, 0, 10} {
+		s.add(value)
+	}
+	assert.InEpsilon(t, -100, s.quantile(0.1), sketchRelativeAccuracy)
+	assert.InEpsilon(t, -10, s.quantile(0.25), sketchRelativeAccuracy)
+	assert.Equal(t, 0.0, s.quantile(0.5))
+	assert.Equal(t, 0.0, s.quantile(0.75))
+	assert.Equal(t, 10.0, s.quantile(1))
+	assert.True(t, math.IsNaN(newSketch().quantile(0.5)))
+}
+
+func TestSketchMerge(t *testing.T) {
+	low, high, all := newSketch(), newSketch(), newSketch()
+	for i := 1; i <= 1000; i++ {
+		low.add(float64(i))
+		high.add(float64(i + 1000))
+		all.add(float64(i))
+		all.add(float64(i + 1000))
+	}
+	low.merge(high)
+	low.merge(newSketch())
+
+	assert.Equal(t, all.count, low.count)
+	assert.Equal(t, all.sum, low.sum)
+	assert.Equal(t, 1.0, low.min)
+	assert.Equal(t, 2000.0, low.max)
+	for _, q := range []float64{0.5, 0.95, 0.99} {
+		assert.Equal(t, all.quantile(q), low.quantile(q), "quantile %v", q)
+	}
+}
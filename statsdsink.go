@@ -0,0 +1,128 @@
+package statslogdrain
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+)
+
+// DefaultStatsdAddress is the address of a Datadog agent running next to the drain
+const DefaultStatsdAddress = "127.0.0.1:8125"
+
+// StatsdConfig configures the statsd client sending to the Datadog agent
+type StatsdConfig struct {
+	// Address is host:port for UDP or unix:///path/to/dsd.socket, default DefaultStatsdAddress
+	Address string
+	// FlushInterval is how often buffered metrics are sent, default 100ms
+	FlushInterval time.Duration
+	// MaxPacketSize limits the bytes per packet, default 1432 for UDP and 8192 for Unix sockets
+	MaxPacketSize int
+	// HealthName names the sink in /healthz, default statsd
+	HealthName string
+}
+
+// NewStatsdClient returns a statsd client for config that buffers
+// metrics and sends them in batches of up to MaxPacketSize bytes.
+// Writes to the agent are recorded for the sink named HealthName.
+func NewStatsdClient(config StatsdConfig) (*statsd.Client, error) {
+	address := config.Address
+	if address == "" {
+		address = DefaultStatsdAddress
+	}
+	if config.HealthName == "" {
+		config.HealthName = "statsd"
+	}
+
+	options := []statsd.Option{}
+	if config.FlushInterval > 0 {
+		options = append(options, statsd.WithBufferFlushInterval(config.FlushInterval))
+	}
+	writer := &statsdWriter{name: config.HealthName, network: "udp", address: address}
+	if strings.HasPrefix(address, statsd.UnixAddressPrefix) {
+		writer = &statsdWriter{name: config.HealthName, network: "unixgram", address: strings.TrimPrefix(address, statsd.UnixAddressPrefix)}
+		if config.MaxPacketSize <= 0 {
+			config.MaxPacketSize = statsd.DefaultMaxAgentPayloadSize
+		}
+		options = append(options, statsd.WithBufferPoolSize(statsd.DefaultUDSBufferPoolSize), statsd.WithSenderQueueSize(statsd.DefaultUDSBufferPoolSize))
+	} else if _, _, err := writer.connect(); err != nil {
+		return nil, err
+	}
+	if config.MaxPacketSize > 0 {
+		options = append(options, statsd.WithMaxBytesPerPayload(config.MaxPacketSize))
+	}
+	return statsd.NewWithWriter(writer, options...)
+}
+
+// statsdWriter writes the packets of the statsd client to the agent,
+// recording every write, which the client itself only counts. Like the
+// client's own writers it reconnects after a failed write.
+type statsdWriter struct {
+	mutex   sync.Mutex
+	name    string
+	network string
+	address string
+	conn    net.Conn
+	timeout time.Duration
+}
+
+// connect returns the connection, dialing it if needed, and the write timeout
+func (w *statsdWriter) connect() (net.Conn, time.Duration, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.conn == nil {
+		conn, err := net.Dial(w.network, w.address)
+		if err != nil {
+			return nil, 0, err
+		}
+		w.conn = conn
+	}
+	return w.conn, w.timeout, nil
+}
+
+func (w *statsdWriter) Write(data []byte) (int, error) {
+	n, err := w.write(data)
+	health.record(w.name, err)
+	return n, err
+}
+
+func (w *statsdWriter) write(data []byte) (int, error) {
+	conn, timeout, err := w.connect()
+	if err != nil {
+		return 0, err
+	}
+	if timeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(timeout))
+	}
+	n, err := conn.Write(data)
+	if err != nil {
+		w.mutex.Lock()
+		if w.conn == conn {
+			conn.Close()
+			w.conn = nil
+		}
+		w.mutex.Unlock()
+	}
+	return n, err
+}
+
+// SetWriteTimeout is called by the statsd client with its timeout for Unix sockets
+func (w *statsdWriter) SetWriteTimeout(timeout time.Duration) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.timeout = timeout
+	return nil
+}
+
+func (w *statsdWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
@@ -0,0 +1,64 @@
+package statslogdrain
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readPacket(t *testing.T, conn net.PacketConn) string {
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buffer := make([]byte, 8192)
+	n, _, err := conn.ReadFrom(buffer)
+	assert.NoError(t, err)
+	return string(buffer[:n])
+}
+
+func TestNewStatsdClientUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	statsdClient, err := NewStatsdClient(StatsdConfig{Address: conn.LocalAddr().String(), FlushInterval: 10 * time.Millisecond})
+	assert.NoError(t, err)
+	defer statsdClient.Close()
+
+	statsdClient.Histogram("heroku.router.request.service", 37, []string{"app:test-app"}, 1)
+	assert.Contains(t, readPacket(t, conn), "heroku.router.request.service:37|h|#app:test-app\n")
+}
+
+func TestNewStatsdClientUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dsd.socket")
+	conn, err := net.ListenPacket("unixgram", path)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	statsdClient, err := NewStatsdClient(StatsdConfig{Address: "unix://" + path, FlushInterval: 10 * time.Millisecond})
+	assert.NoError(t, err)
+	defer statsdClient.Close()
+
+	statsdClient.Count("heroku.logdrain.lines", 3, []string{"app:test-app"}, 1)
+	assert.Contains(t, readPacket(t, conn), "heroku.logdrain.lines:3|c|#app:test-app\n")
+}
+
+func TestNewStatsdClientRecordsFailedWrites(t *testing.T) {
+	useSinkHealth(t, 1)
+	path := filepath.Join(t.TempDir(), "missing.socket")
+
+	statsdClient, err := NewStatsdClient(StatsdConfig{Address: "unix://" + path, FlushInterval: 10 * time.Millisecond})
+	assert.NoError(t, err)
+	defer statsdClient.Close()
+
+	statsdClient.Count("heroku.logdrain.lines", 3, []string{"app:test-app"}, 1)
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if code, _ := healthz(t); code == 503 {
+			break
+		}
+	}
+	code, status := healthz(t)
+	assert.Equal(t, 503, code)
+	assert.True(t, status.Sinks["statsd"].Errors["no_socket"] > 0)
+}
@@ -6,9 +6,12 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"path"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/DataDog/datadog-go/statsd"
 )
@@ -16,36 +19,91 @@ import (
 // LogdrainServer parses Heroku logdrain requests
 // and sends stats to datadog via statsd protocol
 func LogdrainServer(w http.ResponseWriter, req *http.Request) {
-	userName, valid := passwordValid(req)
+	userName, valid := authenticateRequest(w, req, "")
 	if !valid {
-		log.Println("Unauthorized request:", req.URL)
-		http.Error(w, "Unauthorized", 401)
 		return
 	}
+	drain(w, req, userName)
+}
+
+// DrainsPath is the prefix of the per-app drain endpoints served by AppDrainServer
+const DrainsPath = "/drains/"
 
+// AppDrainServer serves the drain of the app named in the path, e.g.
+// /drains/my-app, for requests authenticated as that app only. Its lines
+// are processed with the app's AppConfig, including its tags and sink.
+func AppDrainServer(w http.ResponseWriter, req *http.Request) {
+	app := strings.TrimPrefix(req.URL.Path, DrainsPath)
+	if !strings.HasPrefix(req.URL.Path, DrainsPath) || app == "" || strings.Contains(app, "/") {
+		http.NotFound(w, req)
+		return
+	}
+
+	if _, valid := authenticateRequest(w, req, app); !valid {
+		return
+	}
+	drain(w, req, app)
+}
+
+// drain processes the log lines of an authenticated request of userName
+func drain(w http.ResponseWriter, req *http.Request, userName string) {
 	scanner := bufio.NewScanner(req.Body)
 	defer req.Body.Close()
 
-	lines := 0
+	sink := appSink(appConfigFor(userName))
+	limiter := appLimiterFor(userName)
+	if limiter == nil {
+		for scanner.Scan() {
+			processLine(sink, scanner.Text(), userName)
+		}
+		logScanError(scanner)
+		return
+	}
+
+	lines := []string{}
 	for scanner.Scan() {
-		if err := scanner.Err(); err != nil {
-			log.Println("error reading body:", err)
-		} else {
-			processLine(scanner.Text(), userName)
+		lines = append(lines, scanner.Text())
+	}
+	logScanError(scanner)
+
+	admitted, rate := limiter.admitLines(len(lines), time.Now())
+	limited := &limitedClient{MetricSink: sink, bucket: limiter.metrics, usage: &sinkUsage{}, sampleRate: rate}
+	droppedLines := 0
+	for i, line := range lines {
+		if !admitted(i) {
+			droppedLines++
+			continue
 		}
-		lines++
+		processLine(limited, line, userName)
+	}
+	reportUsage(userName, len(lines), droppedLines, limited)
+
+	if droppedLines > 0 && limiter.reject {
+		http.Error(w, "Too Many Requests", 429)
+	}
+}
+
+func logScanError(scanner *bufio.Scanner) {
+	if err := scanner.Err(); err != nil {
+		log.Println("error reading body:", err)
 	}
 }
 
 const metricsPrefix = "sample#"
 
-func processLine(line, userName string) {
+func processLine(sink MetricSink, line, userName string) {
+	sink = atLogTime(sink, line)
+	config := appConfigFor(userName)
+	countLine(sink, config.CounterRules, line, userName)
+
 	if strings.Contains(line, "router") {
-		handleLine(handleRouterLine, line, userName)
-	} else if strings.Contains(line, "logdrain-metrics") {
-		handleLine(handleMetricLine, line, userName)
+		handleLine(sink, handleRouterLine, line, userName)
+	} else if config.isMetricLine(line) {
+		handleLine(sink, handleMetricLine, line, userName)
 	} else if strings.Contains(line, "sample#load") || strings.Contains(line, "sample#memory") {
-		handleLine(handleDynoMetrics, line, userName)
+		handleLine(sink, handleDynoMetrics, line, userName)
+	} else if isPlatformLine(line) {
+		handlePlatformLine(sink, line, userName)
 	} else {
 		if enableDrainLogging {
 			log.Println("unhandled line:", line)
@@ -53,35 +111,226 @@ func processLine(line, userName string) {
 	}
 }
 
-func handleLine(handler lineHandler, line string, userName string) {
+func handleLine(sink MetricSink, handler lineHandler, line string, userName string) {
 	values := mapFromLine(line)
 	tags := collectTags(values, userName)
 
-	handler(values, tags)
+	handler(sink, values, tags, userName)
 }
 
-type lineHandler func(values map[string]string, tags []string)
+type lineHandler func(sink MetricSink, values map[string]string, tags []string, userName string)
 
-func handleRouterLine(values map[string]string, tags []string) {
-	client.Histogram("heroku.router.request.bytes", parseFloat(values["bytes"]), tags, 1)
-	client.Histogram("heroku.router.request.connect", parseFloat(values["connect"]), tags, 1)
-	client.Histogram("heroku.router.request.service", parseFloat(values["service"]), tags, 1)
+func handleRouterLine(sink MetricSink, values map[string]string, tags []string, userName string) {
+	recordSend(sink.Histogram("heroku.router.request.bytes", parseFloat(values["bytes"]), tags, 1))
+	recordSend(sink.Histogram("heroku.router.request.connect", parseFloat(values["connect"]), tags, 1))
+	recordSend(sink.Histogram("heroku.router.request.service", parseFloat(values["service"]), tags, 1))
 }
 
-func handleMetricLine(values map[string]string, tags []string) {
+func handleMetricLine(sink MetricSink, values map[string]string, tags []string, userName string) {
+	config := appConfigFor(userName)
 	for k, v := range values {
 		if strings.HasPrefix(k, metricsPrefix) {
-			sampleName := strings.TrimPrefix(k, metricsPrefix)
-			client.Histogram(fmt.Sprintf("heroku.custom.%s", sampleName), parseFloat(v), tags, 1)
+			sampleName := sanitizeMetricName(strings.TrimPrefix(k, metricsPrefix))
+			if sampleName == "" || !config.metricAllowed(sampleName) {
+				continue
+			}
+			recordSend(sink.Histogram(config.customMetricName(sampleName), parseFloat(v), tags, 1))
 		}
 	}
 }
 
-func handleDynoMetrics(values map[string]string, tags []string) {
+func handleDynoMetrics(sink MetricSink, values map[string]string, tags []string, userName string) {
 	for k, v := range values {
 		if strings.HasPrefix(k, metricsPrefix) {
 			sampleName := strings.TrimPrefix(k, metricsPrefix)
-			client.Histogram(fmt.Sprintf("heroku.dyno.%s", sampleName), parseFloat(v), tags, 1)
+			recordSend(sink.Histogram(fmt.Sprintf("heroku.dyno.%s", sampleName), parseFloat(v), tags, 1))
+		}
+	}
+}
+
+const (
+	defaultMetricsNamespace = "heroku.custom."
+	maxMetricNameLength     = 200
+)
+
+var (
+	invalidMetricCharsRegexp = regexp.MustCompile(`[^a-z0-9_.]+`)
+	repeatedDotsRegexp       = regexp.MustCompile(`\.{2,}`)
+)
+
+// sanitizeMetricName normalizes name to Datadog's metric naming rules:
+// lowercase ASCII alphanumerics, underscores and periods, starting with a letter.
+func sanitizeMetricName(name string) string {
+	name = strings.ToLower(name)
+	name = invalidMetricCharsRegexp.ReplaceAllLiteralString(name, "_")
+	name = repeatedDotsRegexp.ReplaceAllLiteralString(name, ".")
+	name = strings.TrimLeft(name, "0123456789_.")
+	name = strings.TrimRight(name, "_.")
+	if len(name) > maxMetricNameLength {
+		name = strings.TrimRight(name[:maxMetricNameLength], "_.")
+	}
+	return name
+}
+
+// AppConfig holds the settings of a single app sending to the drain
+type AppConfig struct {
+	// MetricsNamespace is prepended to custom metric names,
+	// defaults to "heroku.custom."
+	MetricsNamespace string
+	// AllowedMetrics restricts custom metrics to names matching one of
+	// these patterns (see path.Match). Empty means all metrics are allowed.
+	AllowedMetrics []string
+	// MetricSources selects the lines carrying custom metrics,
+	// defaults to lines containing "logdrain-metrics"
+	MetricSources []MetricSource
+	// CounterRules count log lines matching a pattern
+	CounterRules []CounterRule
+	// LinesPerSecond and MetricsPerSecond limit what the app can send
+	// to the drain, allowing bursts of one second's worth. 0 means unlimited.
+	LinesPerSecond   float64
+	MetricsPerSecond float64
+	// RejectOverLimit responds with 429 Too Many Requests to requests
+	// over the lines limit instead of accepting them and sampling the lines
+	RejectOverLimit bool
+	// Tags are added to all of the app's metrics, e.g. "team:payments"
+	Tags []string
+	// Sink receives the app's metrics instead of the sink set with SetMetricSink
+	Sink MetricSink
+}
+
+// MetricSource matches log lines carrying custom metrics. Set exactly one field.
+type MetricSource struct {
+	// Source matches the line's source=<value> pair
+	Source string
+	// ProcID matches the syslog procid of the app's own lines, either a dyno
+	// (web.1) or a process type (web), not Heroku's lines about that dyno
+	ProcID string
+	// Pattern matches anywhere in the line
+	Pattern *regexp.Regexp
+}
+
+// ParseMetricSource parses "procid:<procid>", "regex:<expression>" or a plain source value
+func ParseMetricSource(s string) (MetricSource, error) {
+	switch {
+	case strings.HasPrefix(s, "procid:"):
+		return MetricSource{ProcID: strings.TrimPrefix(s, "procid:")}, nil
+	case strings.HasPrefix(s, "regex:"):
+		pattern, err := regexp.Compile(strings.TrimPrefix(s, "regex:"))
+		if err != nil {
+			return MetricSource{}, err
+		}
+		return MetricSource{Pattern: pattern}, nil
+	default:
+		return MetricSource{Source: strings.TrimPrefix(s, "source:")}, nil
+	}
+}
+
+func (m MetricSource) matches(line string) bool {
+	switch {
+	case m.Source != "":
+		return hasPair(line, "source", m.Source)
+	case m.ProcID != "":
+		header, _ := parseSyslogLine(line)
+		return header.appName == "app" && (header.procID == m.ProcID || header.processType() == m.ProcID)
+	case m.Pattern != nil:
+		return m.Pattern.MatchString(line)
+	}
+	return false
+}
+
+func (c AppConfig) isMetricLine(line string) bool {
+	if len(c.MetricSources) == 0 {
+		return strings.Contains(line, "logdrain-metrics")
+	}
+	for _, source := range c.MetricSources {
+		if source.matches(line) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c AppConfig) customMetricName(sampleName string) string {
+	namespace := c.MetricsNamespace
+	if namespace == "" {
+		namespace = defaultMetricsNamespace
+	} else if !strings.HasSuffix(namespace, ".") {
+		namespace += "."
+	}
+
+	return sanitizeMetricName(namespace + sampleName)
+}
+
+func (c AppConfig) metricAllowed(sampleName string) bool {
+	if len(c.AllowedMetrics) == 0 {
+		return true
+	}
+	for _, pattern := range c.AllowedMetrics {
+		if matched, _ := path.Match(pattern, sampleName); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// CounterRule increments the counter heroku.logs.<Name> for every line it matches.
+// Set either Key and Value or Pattern.
+type CounterRule struct {
+	Name string
+	// Key and Value match a logfmt pair of the line, e.g. level=error
+	Key, Value string
+	// Pattern matches anywhere in the line, its named groups become tags
+	Pattern *regexp.Regexp
+}
+
+var logfmtPairRegexp = regexp.MustCompile(`^[^\s="]+=[^\s"]+$`)
+
+// ParseCounterRule parses a logfmt pair like "level=error", "regex:<expression>"
+// or any other regular expression like "Exception" into a rule named name
+func ParseCounterRule(name, s string) (CounterRule, error) {
+	rule := CounterRule{Name: sanitizeMetricName(name)}
+	if rule.Name == "" {
+		return CounterRule{}, fmt.Errorf("invalid counter name %q", name)
+	}
+
+	if !strings.HasPrefix(s, "regex:") && logfmtPairRegexp.MatchString(s) {
+		keyValue := strings.SplitN(s, "=", 2)
+		rule.Key, rule.Value = keyValue[0], keyValue[1]
+		return rule, nil
+	}
+
+	pattern, err := regexp.Compile(strings.TrimPrefix(s, "regex:"))
+	if err != nil {
+		return CounterRule{}, err
+	}
+	rule.Pattern = pattern
+	return rule, nil
+}
+
+// match reports whether line matches the rule and returns the tags captured by it
+func (r CounterRule) match(line string) (bool, []string) {
+	if r.Pattern == nil {
+		return r.Key != "" && hasPair(line, r.Key, r.Value), nil
+	}
+
+	groups := r.Pattern.FindStringSubmatch(line)
+	if groups == nil {
+		return false, nil
+	}
+	tags := []string{}
+	for i, name := range r.Pattern.SubexpNames() {
+		if name != "" && groups[i] != "" {
+			tags = append(tags, fmt.Sprintf("%s:%s", name, groups[i]))
+		}
+	}
+	return true, tags
+}
+
+func countLine(sink MetricSink, rules []CounterRule, line, userName string) {
+	for _, rule := range rules {
+		if matched, tags := rule.match(line); matched {
+			tags = append(tags, fmt.Sprintf("app:%v", userName))
+			recordSend(sink.Count("heroku.logs."+rule.Name, 1, tags, 1))
 		}
 	}
 }
@@ -122,11 +371,15 @@ func mapFromLine(line string) map[string]string {
 	return result
 }
 
-var userPasswords map[string]string
+var (
+	appConfigs      map[string]AppConfig
+	appConfigsMutex sync.RWMutex
+)
 
-func passwordValid(req *http.Request) (string, bool) {
-	username, password, ok := req.BasicAuth()
-	return username, (ok && (password == userPasswords[username]))
+func appConfigFor(userName string) AppConfig {
+	appConfigsMutex.RLock()
+	defer appConfigsMutex.RUnlock()
+	return appConfigs[userName]
 }
 
 var floatRegexp = regexp.MustCompile(`[^.0-9]`)
@@ -141,28 +394,84 @@ func parseFloat(str string) float64 {
 	return f
 }
 
-// StatsDClient is used to make testing easier
-type statsDClient interface {
-	Histogram(name string, value float64, tags []string, rate float64) error
+// MetricSink receives the metrics, events and service checks of the drain.
+// *statsd.Client is the default implementation, sending them to a Datadog agent.
+type MetricSink interface {
+	Gauge(name string, value float64, tags []string, rate float64) error
 	Count(name string, value int64, tags []string, rate float64) error
+	Histogram(name string, value float64, tags []string, rate float64) error
+	Set(name string, value string, tags []string, rate float64) error
+	TimeInMilliseconds(name string, value float64, tags []string, rate float64) error
+	Event(e *statsd.Event) error
+	ServiceCheck(sc *statsd.ServiceCheck) error
+}
+
+// noSink discards everything sent to it
+var noSink = &statsd.NoOpClient{}
+
+// client discards everything until a sink is set with SetMetricSink
+var client MetricSink = noSink
+
+var warnNoSinkOnce sync.Once
+
+// warnNoSink logs once that metrics are discarded, as embedders that
+// never call SetMetricSink used to get a statsd client on 127.0.0.1:8125
+func warnNoSink() {
+	warnNoSinkOnce.Do(func() {
+		log.Println("no metric sink set, discarding metrics: call SetMetricSink, e.g. with a client from NewStatsdClient")
+	})
+}
+
+// TimestampedSink is a MetricSink that can record metrics at the time
+// they were logged rather than when they reach the drain
+type TimestampedSink interface {
+	MetricSink
+	// WithTimestamp returns a sink recording everything sent to it at timestamp
+	WithTimestamp(timestamp time.Time) MetricSink
+}
+
+// atLogTime returns sink recording the metrics of line at the time it
+// was logged, if sink supports that
+func atLogTime(sink MetricSink, line string) MetricSink {
+	timestamped, ok := sink.(TimestampedSink)
+	if !ok {
+		return sink
+	}
+	header, _ := parseSyslogLine(line)
+	if timestamp, ok := header.parsedTimestamp(); ok {
+		return timestamped.WithTimestamp(timestamp)
+	}
+	return sink
 }
 
-var client statsDClient
+// SetMetricSink sets the sink all metrics, events and service checks are sent to,
+// e.g. a client from NewStatsdClient
+func SetMetricSink(sink MetricSink) {
+	client = sink
+}
 
 var enableDrainLogging = false
 
 // SetUserpasswords sets the required user/password map for authentication
 func SetUserpasswords(passwordMap map[string]string) {
-	userPasswords = passwordMap
+	SetAuthenticator(BasicAuthenticator(passwordMap))
 }
 
-func init() {
-	var err error
-	client, err = statsd.New("127.0.0.1:8125")
-	if err != nil {
-		log.Fatal(err)
-	}
+// SetUserCredentials sets the valid credentials of each app for authentication
+func SetUserCredentials(credentials map[string][]Credential) {
+	SetAuthenticator(CredentialsAuthenticator(credentials))
+}
+
+// SetAppConfigs sets the per-app configuration, keyed by app name
+func SetAppConfigs(configs map[string]AppConfig) {
+	setAppLimiters(configs)
 
+	appConfigsMutex.Lock()
+	defer appConfigsMutex.Unlock()
+	appConfigs = configs
+}
+
+func init() {
 	enabled, err := strconv.ParseBool(os.Getenv("ENABLE_DRAIN_METRICS"))
 	if err != nil {
 		enableDrainLogging = enabled
@@ -4,11 +4,37 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
 )
 
 func BenchmarkHttpEndpoint(b *testing.B) {
-	client = &noopClient{}
+	noop := &noopClient{}
+	benchmarkHttpEndpoint(b, noop, func() {})
+	b.ReportMetric(float64(noop.sends)/float64(b.N), "sends/op")
+}
+
+// BenchmarkHttpEndpointAggregated is BenchmarkHttpEndpoint with metrics
+// aggregated in-process, flushing once per 1000 requests
+func BenchmarkHttpEndpointAggregated(b *testing.B) {
+	noop := &noopClient{}
+	sink := NewAggregatingSink(noop, AggregatingConfig{FlushInterval: time.Hour})
+	defer sink.Close()
+	requests := 0
+	benchmarkHttpEndpoint(b, sink, func() {
+		if requests++; requests%1000 == 0 {
+			sink.Flush()
+		}
+	})
+	sink.Flush()
+	b.ReportMetric(float64(noop.sends)/float64(b.N), "sends/op")
+}
+
+func benchmarkHttpEndpoint(b *testing.B, sink MetricSink, afterRequest func()) {
+	client = sink
 	SetUserpasswords(map[string]string{"test-app": "deadbeef"})
 	b.ResetTimer()
 
@@ -17,6 +43,7 @@ func BenchmarkHttpEndpoint(b *testing.B) {
 		req.SetBasicAuth("test-app", "deadbeef")
 		w := httptest.NewRecorder()
 		LogdrainServer(w, req)
+		afterRequest()
 	}
 }
 
@@ -33,13 +60,42 @@ func BenchmarkMapFromLine(b *testing.B) {
 	}
 }
 
+// noopClient drops every metric, counting how many were sent
 type noopClient struct {
+	sends int64
 }
 
 func (c *noopClient) Histogram(name string, value float64, tags []string, rate float64) error {
+	atomic.AddInt64(&c.sends, 1)
 	return nil
 }
 
 func (c *noopClient) Count(name string, value int64, tags []string, rate float64) error {
+	atomic.AddInt64(&c.sends, 1)
+	return nil
+}
+
+func (c *noopClient) Gauge(name string, value float64, tags []string, rate float64) error {
+	atomic.AddInt64(&c.sends, 1)
+	return nil
+}
+
+func (c *noopClient) Set(name string, value string, tags []string, rate float64) error {
+	atomic.AddInt64(&c.sends, 1)
+	return nil
+}
+
+func (c *noopClient) TimeInMilliseconds(name string, value float64, tags []string, rate float64) error {
+	atomic.AddInt64(&c.sends, 1)
+	return nil
+}
+
+func (c *noopClient) Event(e *statsd.Event) error {
+	atomic.AddInt64(&c.sends, 1)
+	return nil
+}
+
+func (c *noopClient) ServiceCheck(sc *statsd.ServiceCheck) error {
+	atomic.AddInt64(&c.sends, 1)
 	return nil
 }
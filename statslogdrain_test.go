@@ -1,13 +1,16 @@
 package statslogdrain
 
 import (
+	"bytes"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 
+	"github.com/DataDog/datadog-go/statsd"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -26,6 +29,70 @@ func TestUnauthorized(t *testing.T) {
 	assert.Equal(t, 401, w.Code)
 }
 
+func TestAppDrainServer(t *testing.T) {
+	initServer()
+	SetUserpasswords(map[string]string{"test-app": "deadbeef", "other-app": "cafebabe"})
+	SetAppConfigs(map[string]AppConfig{"test-app": {MetricsNamespace: "heroku.custom.test_app"}})
+
+	req, _ := http.NewRequest("POST", "http://example.com/drains/test-app", strings.NewReader(strings.TrimSpace(customMetricsBody)))
+	req.SetBasicAuth("test-app", "deadbeef")
+	w := httptest.NewRecorder()
+	AppDrainServer(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, []command{
+		{"heroku.custom.test_app.s3_request.total", 537, []string{"source:logdrain-metrics", "app:test-app"}},
+	}, client.(*stubClient).histograms)
+
+	req, _ = http.NewRequest("POST", "http://example.com/drains/test-app", strings.NewReader(strings.TrimSpace(customMetricsBody)))
+	req.SetBasicAuth("other-app", "cafebabe")
+	w = httptest.NewRecorder()
+	AppDrainServer(w, req)
+	assert.Equal(t, 401, w.Code, "credentials of another app")
+	assert.Len(t, client.(*stubClient).histograms, 1)
+
+	for _, path := range []string{"/drains/", "/drains/test-app/extra"} {
+		req, _ = http.NewRequest("POST", "http://example.com"+path, strings.NewReader(""))
+		req.SetBasicAuth("test-app", "deadbeef")
+		w = httptest.NewRecorder()
+		AppDrainServer(w, req)
+		assert.Equal(t, 404, w.Code, path)
+	}
+}
+
+func TestAppDrainServerTagsAndSink(t *testing.T) {
+	initServer()
+	own := &stubClient{}
+	SetAppConfigs(map[string]AppConfig{"test-app": {Tags: []string{"team:payments"}, Sink: own}})
+
+	req, _ := http.NewRequest("POST", "http://example.com/drains/test-app", strings.NewReader(strings.TrimSpace(customMetricsBody)))
+	req.SetBasicAuth("test-app", "deadbeef")
+	w := httptest.NewRecorder()
+	AppDrainServer(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, []command{
+		{"heroku.custom.s3_request.total", 537, []string{"source:logdrain-metrics", "team:payments", "app:test-app"}},
+	}, own.histograms)
+	assert.Empty(t, client.(*stubClient).histograms)
+}
+
+func TestWarnsWithoutSink(t *testing.T) {
+	initServer()
+	SetMetricSink(noSink)
+	defer SetMetricSink(&stubClient{})
+	warnNoSinkOnce = sync.Once{}
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("POST", "http://example.com/foo", strings.NewReader(strings.TrimSpace(routerMetricsBody)))
+		req.SetBasicAuth("test-app", "deadbeef")
+		w := httptest.NewRecorder()
+		LogdrainServer(w, req)
+		assert.Equal(t, 200, w.Code)
+	}
+	assert.Equal(t, 1, strings.Count(logged.String(), "no metric sink set"))
+}
+
 func TestRouterMetrics(t *testing.T) {
 	initServer()
 
@@ -66,6 +133,140 @@ func TestCustomMetrics(t *testing.T) {
 	}, client.(*stubClient).histograms)
 }
 
+const unsanitizedMetricsBody = `
+542 <134>1 2015-10-06T12:23:58.066218+00:00 app web.10: logdrain-metrics source=logdrain-metrics sample#S3-Request.Total=537.543ms sample#queue..depth=12 sample#jobs.enqueued=3
+`
+
+func TestCustomMetricsSanitized(t *testing.T) {
+	initServer()
+
+	req, _ := http.NewRequest("POST", "http://example.com/foo", strings.NewReader(strings.TrimSpace(unsanitizedMetricsBody)))
+	req.SetBasicAuth("test-app", "deadbeef")
+
+	w := httptest.NewRecorder()
+	LogdrainServer(w, req)
+	assert.Len(t, client.(*stubClient).histograms, 3)
+	assert.Contains(t, client.(*stubClient).histograms, command{"heroku.custom.s3_request.total", 537, []string{"source:logdrain-metrics", "app:test-app"}})
+	assert.Contains(t, client.(*stubClient).histograms, command{"heroku.custom.queue.depth", 12, []string{"source:logdrain-metrics", "app:test-app"}})
+	assert.Contains(t, client.(*stubClient).histograms, command{"heroku.custom.jobs.enqueued", 3, []string{"source:logdrain-metrics", "app:test-app"}})
+}
+
+func TestCustomMetricsNamespaceAndAllowlist(t *testing.T) {
+	initServer()
+	SetAppConfigs(map[string]AppConfig{
+		"test-app": {MetricsNamespace: "heroku.custom.test_app", AllowedMetrics: []string{"s3_request.*", "queue.depth"}},
+	})
+
+	req, _ := http.NewRequest("POST", "http://example.com/foo", strings.NewReader(strings.TrimSpace(unsanitizedMetricsBody)))
+	req.SetBasicAuth("test-app", "deadbeef")
+
+	w := httptest.NewRecorder()
+	LogdrainServer(w, req)
+	assert.Len(t, client.(*stubClient).histograms, 2)
+	assert.Contains(t, client.(*stubClient).histograms, command{"heroku.custom.test_app.s3_request.total", 537, []string{"source:logdrain-metrics", "app:test-app"}})
+	assert.Contains(t, client.(*stubClient).histograms, command{"heroku.custom.test_app.queue.depth", 12, []string{"source:logdrain-metrics", "app:test-app"}})
+}
+
+const gemMetricsBody = `
+300 <190>1 2015-10-06T12:23:58.066218+00:00 host app web.1 - source=metrics sample#cache.hits=17
+300 <190>1 2015-10-06T12:23:58.066218+00:00 host app worker.2 - sample#jobs.done=4
+300 <190>1 2015-10-06T12:23:58.066218+00:00 host app web.1 - measure sample#db.pool=5
+300 <190>1 2015-10-06T12:23:58.066218+00:00 host app web.1 - source=logdrain-metrics sample#ignored=1
+`
+
+func TestCustomMetricSources(t *testing.T) {
+	initServer()
+	sources := []MetricSource{}
+	for _, s := range []string{"metrics", "procid:worker", "regex:^.*measure sample#"} {
+		source, err := ParseMetricSource(s)
+		assert.NoError(t, err)
+		sources = append(sources, source)
+	}
+	SetAppConfigs(map[string]AppConfig{"test-app": {MetricSources: sources}})
+
+	req, _ := http.NewRequest("POST", "http://example.com/foo", strings.NewReader(strings.TrimSpace(gemMetricsBody)))
+	req.SetBasicAuth("test-app", "deadbeef")
+
+	w := httptest.NewRecorder()
+	LogdrainServer(w, req)
+	assert.Equal(t, []command{
+		{"heroku.custom.cache.hits", 17, []string{"source:metrics", "app:test-app"}},
+		{"heroku.custom.jobs.done", 4, []string{"app:test-app"}},
+		{"heroku.custom.db.pool", 5, []string{"app:test-app"}},
+	}, client.(*stubClient).histograms)
+}
+
+func TestMetricSourceProcIDSkipsHerokuLines(t *testing.T) {
+	source, _ := ParseMetricSource("procid:web")
+	assert.True(t, source.matches(`300 <190>1 2015-10-06T12:23:58.066218+00:00 host app web.1 - sample#queue=3`))
+	assert.False(t, source.matches(`329 <45>1 2015-04-02T11:48:16.839348+00:00 host heroku web.1 - source=web.1 dyno=heroku.35930502.b9de5fce-44b7-4287-99a7-504519070cba sample#memory_total=103.50MB`))
+
+	initServer()
+	SetAppConfigs(map[string]AppConfig{"test-app": {MetricSources: []MetricSource{source}}})
+	body := `329 <45>1 2015-04-02T11:48:16.839348+00:00 host heroku web.1 - source=web.1 dyno=heroku.35930502.b9de5fce-44b7-4287-99a7-504519070cba sample#memory_total=103.50MB`
+	req, _ := http.NewRequest("POST", "http://example.com/foo", strings.NewReader(body))
+	req.SetBasicAuth("test-app", "deadbeef")
+	LogdrainServer(httptest.NewRecorder(), req)
+	assert.Equal(t, "heroku.dyno.memory_total", client.(*stubClient).histograms[0].key)
+}
+
+func TestParseMetricSource(t *testing.T) {
+	source, _ := ParseMetricSource("source:metrics")
+	assert.Equal(t, MetricSource{Source: "metrics"}, source)
+	source, _ = ParseMetricSource("procid:web.1")
+	assert.Equal(t, MetricSource{ProcID: "web.1"}, source)
+	_, err := ParseMetricSource("regex:(")
+	assert.Error(t, err)
+}
+
+const appLogBody = `
+300 <190>1 2015-10-06T12:23:58.066218+00:00 host app web.1 - level=error msg="payment failed"
+300 <190>1 2015-10-06T12:23:59.066218+00:00 host app worker.1 - NoMethodError: undefined method 'name' for nil
+300 <190>1 2015-10-06T12:24:00.066218+00:00 host app web.1 - level=info msg="all good"
+`
+
+func TestLogCounters(t *testing.T) {
+	initServer()
+	errors, err := ParseCounterRule("ERRORS", "level=error")
+	assert.NoError(t, err)
+	exceptions, err := ParseCounterRule("exceptions", `(?P<exception>\w+(Error|Exception)):`)
+	assert.NoError(t, err)
+	SetAppConfigs(map[string]AppConfig{"test-app": {CounterRules: []CounterRule{errors, exceptions}}})
+
+	req, _ := http.NewRequest("POST", "http://example.com/foo", strings.NewReader(strings.TrimSpace(appLogBody)))
+	req.SetBasicAuth("test-app", "deadbeef")
+
+	w := httptest.NewRecorder()
+	LogdrainServer(w, req)
+	assert.Equal(t, []command{
+		{"heroku.logs.errors", 1, []string{"app:test-app"}},
+		{"heroku.logs.exceptions", 1, []string{"exception:NoMethodError", "app:test-app"}},
+	}, client.(*stubClient).counts)
+}
+
+func TestParseCounterRule(t *testing.T) {
+	rule, _ := ParseCounterRule("errors", "level=error")
+	assert.Equal(t, CounterRule{Name: "errors", Key: "level", Value: "error"}, rule)
+	rule, _ = ParseCounterRule("timeouts", "regex:level=error")
+	assert.Equal(t, "level=error", rule.Pattern.String())
+	rule, _ = ParseCounterRule("exceptions", "Exception")
+	assert.Equal(t, "Exception", rule.Pattern.String())
+	_, err := ParseCounterRule("!!", "Exception")
+	assert.Error(t, err)
+	_, err = ParseCounterRule("broken", "(")
+	assert.Error(t, err)
+}
+
+func TestSanitizeMetricName(t *testing.T) {
+	assert.Equal(t, "s3_request.total", sanitizeMetricName("s3_request.total"))
+	assert.Equal(t, "s3_request.total", sanitizeMetricName("S3 Request.Total"))
+	assert.Equal(t, "queue.depth", sanitizeMetricName("queue..depth"))
+	assert.Equal(t, "jobs_per_second", sanitizeMetricName("jobs/per-second"))
+	assert.Equal(t, "requests", sanitizeMetricName("2_requests."))
+	assert.Equal(t, "", sanitizeMetricName("!!!"))
+	assert.Len(t, sanitizeMetricName(strings.Repeat("a", 300)), 200)
+}
+
 const dynoMetricsBody = `
 229 <45>1 2015-04-02T11:48:16.839257+00:00 host heroku web.1 - source=web.1 dyno=heroku.35930502.b9de5fce-44b7-4287-99a7-504519070cba sample#load_avg_1m=0.01 sample#load_avg_5m=0.02 sample#load_avg_15m=0.03\n
 329 <45>1 2015-04-02T11:48:16.839348+00:00 host heroku web.1 - source=web.1 dyno=heroku.35930502.b9de5fce-44b7-4287-99a7-504519070cba sample#memory_total=103.50MB sample#memory_rss=94.70MB sample#memory_cache=0.32MB sample#memory_swap=8.48MB sample#memory_pgpgin=36091pages sample#memory_pgpgout=11765pages
@@ -127,8 +328,12 @@ func TestParseFloat(t *testing.T) {
 }
 
 type stubClient struct {
-	histograms []command
-	counts     []command
+	histograms    []command
+	counts        []command
+	gauges        []command
+	sets          []string
+	events        []*statsd.Event
+	serviceChecks []*statsd.ServiceCheck
 }
 
 func (c *stubClient) Histogram(name string, value float64, tags []string, rate float64) error {
@@ -141,6 +346,30 @@ func (c *stubClient) Count(name string, value int64, tags []string, rate float64
 	return nil
 }
 
+func (c *stubClient) Gauge(name string, value float64, tags []string, rate float64) error {
+	c.gauges = append(c.gauges, command{name, int64(value), tags})
+	return nil
+}
+
+func (c *stubClient) Set(name string, value string, tags []string, rate float64) error {
+	c.sets = append(c.sets, name+":"+value)
+	return nil
+}
+
+func (c *stubClient) TimeInMilliseconds(name string, value float64, tags []string, rate float64) error {
+	return c.Histogram(name, value, tags, rate)
+}
+
+func (c *stubClient) Event(e *statsd.Event) error {
+	c.events = append(c.events, e)
+	return nil
+}
+
+func (c *stubClient) ServiceCheck(sc *statsd.ServiceCheck) error {
+	c.serviceChecks = append(c.serviceChecks, sc)
+	return nil
+}
+
 type command struct {
 	key   string
 	value int64
@@ -148,7 +377,10 @@ type command struct {
 }
 
 func initServer() {
-	client = &stubClient{}
+	SetMetricSink(&stubClient{})
 	SetUserpasswords(map[string]string{"test-app": "deadbeef"})
+	SetAppConfigs(nil)
+	exits = newExitTracker()
+	SetAuthLockout(DefaultMaxAuthFailures, DefaultAuthFailureWindow, DefaultAuthLockout)
 	log.SetOutput(ioutil.Discard)
 }
@@ -0,0 +1,95 @@
+package statslogdrain
+
+import (
+	"strings"
+	"time"
+)
+
+// syslogHeader holds the RFC 5424 header fields Logplex frames each line with, e.g.
+// 255 <158>1 2015-04-02T11:52:34.520012+00:00 host heroku router - at=info ...
+type syslogHeader struct {
+	timestamp string
+	hostname  string
+	appName   string
+	procID    string
+}
+
+// parseSyslogLine splits line into its syslog header and message.
+// Lines without a recognizable header are returned as message only.
+func parseSyslogLine(line string) (syslogHeader, string) {
+	line = strings.TrimSpace(line)
+	if i := strings.IndexByte(line, ' '); i > 0 && isDigits(line[:i]) {
+		line = line[i+1:]
+	}
+	if !strings.HasPrefix(line, "<") {
+		return syslogHeader{}, line
+	}
+
+	fields := strings.SplitN(line, " ", 7)
+	if len(fields) < 6 {
+		return syslogHeader{}, line
+	}
+
+	header := syslogHeader{
+		timestamp: fields[1],
+		hostname:  fields[2],
+		appName:   fields[3],
+		procID:    strings.TrimSuffix(fields[4], ":"),
+	}
+	message := ""
+	if len(fields) == 7 {
+		message = fields[6]
+	}
+	return header, message
+}
+
+// parsedTimestamp returns the time the line was logged, in UTC
+func (h syslogHeader) parsedTimestamp() (time.Time, bool) {
+	timestamp, err := time.Parse(time.RFC3339Nano, h.timestamp)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return timestamp.UTC(), true
+}
+
+// loggedAt returns when the line was logged, or the current time if unknown,
+// so that late or replayed batches are tracked at the time of their lines
+func (h syslogHeader) loggedAt() time.Time {
+	if timestamp, ok := h.parsedTimestamp(); ok {
+		return timestamp
+	}
+	return time.Now()
+}
+
+// processType returns the process type part of the procid, "web" for "web.1"
+func (h syslogHeader) processType() string {
+	if i := strings.IndexByte(h.procID, '.'); i >= 0 {
+		return h.procID[:i]
+	}
+	return h.procID
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// hasPair reports whether line contains the logfmt pair key=value
+func hasPair(line, key, value string) bool {
+	pair := key + "=" + value
+	for offset := 0; ; {
+		i := strings.Index(line[offset:], pair)
+		if i < 0 {
+			return false
+		}
+		start, end := offset+i, offset+i+len(pair)
+		if (start == 0 || line[start-1] == ' ') && (end == len(line) || line[end] == ' ') {
+			return true
+		}
+		offset = end
+	}
+}
@@ -0,0 +1,30 @@
+package statslogdrain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSyslogLine(t *testing.T) {
+	header, message := parseSyslogLine(`255 <158>1 2015-04-02T11:52:34.520012+00:00 host heroku router - at=info method=POST path="/users"`)
+	assert.Equal(t, syslogHeader{"2015-04-02T11:52:34.520012+00:00", "host", "heroku", "router"}, header)
+	assert.Equal(t, `at=info method=POST path="/users"`, message)
+
+	header, message = parseSyslogLine(`83 <40>1 2012-11-30T06:45:29+00:00 host app web.3 - State changed from starting to up`)
+	assert.Equal(t, "web.3", header.procID)
+	assert.Equal(t, "web", header.processType())
+	assert.Equal(t, "State changed from starting to up", message)
+
+	header, message = parseSyslogLine("no header here")
+	assert.Equal(t, syslogHeader{}, header)
+	assert.Equal(t, "no header here", message)
+}
+
+func TestHasPair(t *testing.T) {
+	assert.True(t, hasPair("source=metrics sample#a=1", "source", "metrics"))
+	assert.True(t, hasPair("at=info source=metrics", "source", "metrics"))
+	assert.False(t, hasPair("source=metrics2 sample#a=1", "source", "metrics"))
+	assert.False(t, hasPair("mysource=metrics", "source", "metrics"))
+	assert.True(t, hasPair("mysource=metrics source=metrics", "source", "metrics"))
+}
@@ -0,0 +1,82 @@
+package statslogdrain
+
+import (
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+)
+
+// taggedSink adds the static tags of an app to everything sent to it
+type taggedSink struct {
+	MetricSink
+	tags []string
+}
+
+// appSink returns the sink of the app's metrics, its own if configured, adding its tags
+func appSink(config AppConfig) MetricSink {
+	sink := client
+	if config.Sink != nil {
+		sink = config.Sink
+	}
+	if sink == noSink {
+		warnNoSink()
+	}
+	if len(config.Tags) > 0 {
+		sink = &taggedSink{MetricSink: sink, tags: config.Tags}
+	}
+	return sink
+}
+
+// with returns tags with the static tags added before the app tag, which stays last
+func (s *taggedSink) with(tags []string) []string {
+	combined := make([]string, 0, len(tags)+len(s.tags))
+	if n := len(tags); n > 0 && strings.HasPrefix(tags[n-1], "app:") {
+		combined = append(combined, tags[:n-1]...)
+		combined = append(combined, s.tags...)
+		return append(combined, tags[n-1])
+	}
+	combined = append(combined, tags...)
+	return append(combined, s.tags...)
+}
+
+// WithTimestamp implements TimestampedSink, passing timestamp on to the tagged sink
+func (s *taggedSink) WithTimestamp(timestamp time.Time) MetricSink {
+	timestamped, ok := s.MetricSink.(TimestampedSink)
+	if !ok {
+		return s
+	}
+	return &taggedSink{MetricSink: timestamped.WithTimestamp(timestamp), tags: s.tags}
+}
+
+func (s *taggedSink) Gauge(name string, value float64, tags []string, rate float64) error {
+	return s.MetricSink.Gauge(name, value, s.with(tags), rate)
+}
+
+func (s *taggedSink) Count(name string, value int64, tags []string, rate float64) error {
+	return s.MetricSink.Count(name, value, s.with(tags), rate)
+}
+
+func (s *taggedSink) Histogram(name string, value float64, tags []string, rate float64) error {
+	return s.MetricSink.Histogram(name, value, s.with(tags), rate)
+}
+
+func (s *taggedSink) Set(name string, value string, tags []string, rate float64) error {
+	return s.MetricSink.Set(name, value, s.with(tags), rate)
+}
+
+func (s *taggedSink) TimeInMilliseconds(name string, value float64, tags []string, rate float64) error {
+	return s.MetricSink.TimeInMilliseconds(name, value, s.with(tags), rate)
+}
+
+func (s *taggedSink) Event(e *statsd.Event) error {
+	tagged := *e
+	tagged.Tags = s.with(e.Tags)
+	return s.MetricSink.Event(&tagged)
+}
+
+func (s *taggedSink) ServiceCheck(sc *statsd.ServiceCheck) error {
+	tagged := *sc
+	tagged.Tags = s.with(sc.Tags)
+	return s.MetricSink.ServiceCheck(&tagged)
+}